@@ -0,0 +1,55 @@
+// Command reencrypt rewrites the encrypted columns with the current
+// key-encryption key. Run it after adding a new key version to
+// OIDC_DEMO_KEKS, and only remove the old version once it has finished.
+// It reads the same configuration as the server, so run it from the same
+// directory.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/keyrotation"
+)
+
+func main() {
+	batchSize := flag.Int("batch-size", 500, "number of rows to rewrite per transaction")
+	flag.Parse()
+
+	if *batchSize <= 0 {
+		log.Fatal("batch-size must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	resolver, err := deps.InitDepsResolver(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resolver.Close()
+
+	log.Printf("re-encrypting with key version %d", resolver.Keyring.CurrentVersion())
+
+	reencrypter := keyrotation.Reencrypter{
+		Resolver:  &resolver,
+		BatchSize: int32(*batchSize),
+	}
+
+	result, err := reencrypter.Run(ctx)
+	log.Printf(
+		"re-encrypted %d identity tokens, %d identity claims, %d token revocations and %d session ID tokens",
+		result.IdentityTokens,
+		result.IdentityClaims,
+		result.TokenRevocations,
+		result.SessionIDTokens,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -2,12 +2,14 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
 	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
 	"github.com/Nick-Anderssohn/oidc-demo/internal/session"
 	"github.com/Nick-Anderssohn/oidc-demo/internal/user"
+	"github.com/go-chi/chi"
 )
 
 type Handlers struct {
@@ -45,7 +47,11 @@ func (h *Handlers) DeleteMe(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.DepResolver.Queries.DeleteUser(r.Context(), userID)
+	userSVC := user.Service{
+		Resolver: h.DepResolver,
+	}
+
+	err = userSVC.DeleteUser(r.Context(), userID)
 	if err != nil {
 		log.Printf("could not delete user %v", userID.String())
 		http.Error(w, "could not delete user", http.StatusInternalServerError)
@@ -58,17 +64,137 @@ func (h *Handlers) DeleteMe(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// DeleteIdentity unlinks one of the user's identities and revokes the tokens
+// its provider issued.
+func (h *Handlers) DeleteIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, err := session.UserIDFromContext(r.Context())
+	if err != nil {
+		log.Printf("Failed to get user ID from context: %v", err)
+		http.Error(w, "Failed to get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	userSVC := user.Service{
+		Resolver: h.DepResolver,
+	}
+
+	err = userSVC.UnlinkIdentity(r.Context(), userID, chi.URLParam(r, "id"))
+	if errors.Is(err, user.ErrIdentityNotFound) {
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, user.ErrLastIdentity) {
+		http.Error(w, "Cannot unlink the last identity", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to unlink identity: %v", err)
+		http.Error(w, "Failed to unlink identity", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *Handlers) Logout(w http.ResponseWriter, r *http.Request) {
 	sessionSVC := session.Service{
 		Resolver: h.DepResolver,
 	}
 
-	err := sessionSVC.Logout(r.Context(), w)
+	redirectURL, err := sessionSVC.Logout(r.Context(), w)
 	if err != nil {
 		log.Printf("Failed to logout: %v", err)
 		http.Error(w, "Failed to logout", http.StatusInternalServerError)
 		return
 	}
 
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// LogoutCallback is where providers send the user back to after logging them
+// out.
+func (h *Handlers) LogoutCallback(w http.ResponseWriter, r *http.Request) {
+	sessionSVC := session.Service{
+		Resolver: h.DepResolver,
+	}
+
+	// The local session is already gone, so a bad state is only worth noting.
+	if err := sessionSVC.FinishLogout(w, r); err != nil {
+		log.Printf("Logout callback: %v", err)
+	}
+
 	http.Redirect(w, r, "/", http.StatusFound)
 }
+
+func (h *Handlers) ListSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := session.UserIDFromContext(r.Context())
+	if err != nil {
+		log.Printf("Failed to get user ID from context: %v", err)
+		http.Error(w, "Failed to get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	sessionSVC := session.Service{
+		Resolver: h.DepResolver,
+	}
+
+	sessions, err := sessionSVC.ListSessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(sessions); err != nil {
+		http.Error(w, "Failed to encode sessions", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handlers) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	userID, err := session.UserIDFromContext(r.Context())
+	if err != nil {
+		log.Printf("Failed to get user ID from context: %v", err)
+		http.Error(w, "Failed to get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	sessionSVC := session.Service{
+		Resolver: h.DepResolver,
+	}
+
+	err = sessionSVC.RevokeSession(r.Context(), userID, chi.URLParam(r, "id"), w)
+	if errors.Is(err, session.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to revoke session: %v", err)
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteOtherSessions signs the user out everywhere except the current session.
+func (h *Handlers) DeleteOtherSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := session.UserIDFromContext(r.Context())
+	if err != nil {
+		log.Printf("Failed to get user ID from context: %v", err)
+		http.Error(w, "Failed to get user ID from context", http.StatusInternalServerError)
+		return
+	}
+
+	sessionSVC := session.Service{
+		Resolver: h.DepResolver,
+	}
+
+	if _, err := sessionSVC.RevokeOtherSessions(r.Context(), userID); err != nil {
+		log.Printf("Failed to revoke other sessions: %v", err)
+		http.Error(w, "Failed to revoke other sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
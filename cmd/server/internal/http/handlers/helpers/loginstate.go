@@ -0,0 +1,214 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/provider"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/session"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/util"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/oauth2"
+)
+
+// A login transaction is everything we need to remember between redirecting
+// the user to the authorization server and handling the callback. It lives
+// in demo.state_token, keyed by the state parameter.
+
+const loginTransactionTTL = 10 * time.Minute
+
+// loginBindingCookieName is a cookie that ties login transactions to the
+// browser that started them. Only its hash is stored with the transaction,
+// so a state value leaked from one browser can't be redeemed in another.
+const loginBindingCookieName = "login_binding"
+
+func createLoginTransaction(
+	depResolver *deps.Resolver,
+	idp *provider.Provider,
+	w http.ResponseWriter,
+	r *http.Request,
+) (dal.DemoStateToken, error) {
+	stateToken, err := util.GenerateSecureID()
+	if err != nil {
+		return dal.DemoStateToken{}, fmt.Errorf("could not generate state token: %v", err)
+	}
+
+	nonce, err := util.GenerateSecureID()
+	if err != nil {
+		return dal.DemoStateToken{}, fmt.Errorf("could not generate nonce: %v", err)
+	}
+
+	binding, err := ensureLoginBindingCookie(depResolver, w, r)
+	if err != nil {
+		return dal.DemoStateToken{}, err
+	}
+
+	// Remember who is logged in, so the callback links the provider to their
+	// account even if the session cookie doesn't come with it.
+	userID, _ := session.UserIDFromContext(r.Context())
+
+	params := dal.InsertStateTokenParams{
+		Token: stateToken,
+		Nonce: nonce,
+
+		// PKCE (RFC 7636). The S256 challenge goes to the authorization server
+		// now, and the verifier is sent with the token request in the callback,
+		// proving that whoever redeems the code is who started the login.
+		CodeVerifier: oauth2.GenerateVerifier(),
+
+		IdentityProviderID: idp.ID,
+		ReturnTo:           sanitizeReturnTo(r.URL.Query().Get("return_to")),
+		BrowserBinding:     hashBinding(binding),
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  time.Now().Add(loginTransactionTTL),
+			Valid: true,
+		},
+		UserID: userID,
+	}
+
+	if err := depResolver.Queries.InsertStateToken(r.Context(), params); err != nil {
+		return dal.DemoStateToken{}, fmt.Errorf("failed to insert state token: %v", err)
+	}
+
+	return dal.DemoStateToken{
+		Token:              params.Token,
+		CodeVerifier:       params.CodeVerifier,
+		Nonce:              params.Nonce,
+		IdentityProviderID: params.IdentityProviderID,
+		ReturnTo:           params.ReturnTo,
+		BrowserBinding:     params.BrowserBinding,
+		ExpiresAt:          params.ExpiresAt,
+		UserID:             params.UserID,
+	}, nil
+}
+
+// consumeLoginTransaction looks up the login transaction for the state in
+// the callback and deletes it in the same statement, so each state can only
+// be used once. Expired transactions and ones started in another browser
+// are treated as if they don't exist.
+func consumeLoginTransaction(
+	depResolver *deps.Resolver,
+	r *http.Request,
+) (dal.DemoStateToken, error) {
+	state := callbackParam(r, "state")
+	if state == "" {
+		return dal.DemoStateToken{}, fmt.Errorf("state parameter is missing")
+	}
+
+	bindingCookie, err := r.Cookie(loginBindingCookieName)
+	if err != nil || bindingCookie.Value == "" {
+		return dal.DemoStateToken{}, fmt.Errorf("login binding cookie is missing")
+	}
+
+	stateRecord, err := depResolver.Queries.ConsumeStateToken(r.Context(), dal.ConsumeStateTokenParams{
+		Token:          state,
+		BrowserBinding: hashBinding(bindingCookie.Value),
+	})
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return dal.DemoStateToken{}, fmt.Errorf("invalid or expired state token: %s", state)
+		} else {
+			return dal.DemoStateToken{}, fmt.Errorf("failed to consume state token: %v", err)
+		}
+	}
+
+	return stateRecord, nil
+}
+
+// ensureLoginBindingCookie reuses the browser's binding cookie if it has one,
+// so that logins started in several tabs at once all keep working.
+func ensureLoginBindingCookie(
+	depResolver *deps.Resolver,
+	w http.ResponseWriter,
+	r *http.Request,
+) (string, error) {
+	binding := ""
+	if cookie, err := r.Cookie(loginBindingCookieName); err == nil {
+		binding = cookie.Value
+	}
+
+	if binding == "" {
+		var err error
+		binding, err = util.GenerateSecureID()
+		if err != nil {
+			return "", fmt.Errorf("could not generate login binding: %v", err)
+		}
+	}
+
+	secure := strings.HasPrefix(depResolver.Config.APIConfig.BaseURL, "https://")
+
+	// A form_post callback is a cross-site POST, which browsers only send
+	// SameSite=None cookies with. The cookie is shared by every login in
+	// progress, so it is always None: otherwise starting a login with a
+	// query callback provider would break a form_post login in another tab.
+	// SameSite=None cookies have to be Secure, so over plain http (local
+	// development) we stick with Lax.
+	sameSite := http.SameSiteLaxMode
+	if secure {
+		sameSite = http.SameSiteNoneMode
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginBindingCookieName,
+		Value:    binding,
+		MaxAge:   int(loginTransactionTTL / time.Second),
+		SameSite: sameSite,
+		Path:     "/",
+		Secure:   secure,
+		HttpOnly: true,
+	})
+
+	return binding, nil
+}
+
+func hashBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:])
+}
+
+// sanitizeReturnTo only allows local paths so the login flow can't be used
+// as an open redirect.
+func sanitizeReturnTo(returnTo string) string {
+	// Browsers strip tabs and newlines from URLs and treat backslashes as
+	// slashes, so "/<tab>/evil.com" and "/\evil.com" both become "//evil.com".
+	if strings.ContainsFunc(returnTo, isUnsafeInPath) {
+		return "/"
+	}
+
+	u, err := url.Parse(returnTo)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || u.Opaque != "" {
+		return "/"
+	}
+
+	// Also check the decoded path, so "/%2F/evil.com" doesn't slip through
+	// anything that decodes it before redirecting.
+	if !strings.HasPrefix(u.Path, "/") ||
+		strings.HasPrefix(u.Path, "//") ||
+		strings.ContainsFunc(u.Path, isUnsafeInPath) {
+		return "/"
+	}
+
+	return returnTo
+}
+
+func isUnsafeInPath(r rune) bool {
+	return r == '\\' || unicode.IsControl(r) || unicode.IsSpace(r)
+}
+
+// callbackParam reads a parameter of the authorization response, which is in
+// the body for form_post callbacks and in the query string otherwise.
+func callbackParam(r *http.Request, name string) string {
+	if r.Method == http.MethodPost {
+		return r.PostFormValue(name)
+	}
+	return r.URL.Query().Get(name)
+}
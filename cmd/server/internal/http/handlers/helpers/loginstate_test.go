@@ -0,0 +1,96 @@
+package helpers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/config"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+)
+
+func TestSanitizeReturnTo(t *testing.T) {
+	tests := []struct {
+		name     string
+		returnTo string
+		want     string
+	}{
+		{"empty", "", "/"},
+		{"root", "/", "/"},
+		{"local path", "/private/settings", "/private/settings"},
+		{"local path with query", "/search?q=a%20b#results", "/search?q=a%20b#results"},
+		{"absolute url", "https://evil.com/", "/"},
+		{"relative path", "evil.com", "/"},
+		{"javascript", "javascript:alert(1)", "/"},
+		{"protocol relative", "//evil.com", "/"},
+		{"backslash", "/\\evil.com", "/"},
+		{"backslash later", "/foo\\bar", "/"},
+		{"tab", "/\t/evil.com", "/"},
+		{"newline", "/\n/evil.com", "/"},
+		{"carriage return", "/\r/evil.com", "/"},
+		{"space", "/ /evil.com", "/"},
+		{"encoded slash", "/%2F/evil.com", "/"},
+		{"encoded slash lowercase", "/%2f/evil.com", "/"},
+		{"encoded backslash", "/%5Cevil.com", "/"},
+		{"encoded tab", "/%09/evil.com", "/"},
+		{"userinfo", "//user@evil.com", "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeReturnTo(tt.returnTo); got != tt.want {
+				t.Errorf("sanitizeReturnTo(%q) = %q, want %q", tt.returnTo, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureLoginBindingCookie(t *testing.T) {
+	tests := []struct {
+		name         string
+		baseURL      string
+		existing     string
+		wantSameSite http.SameSite
+		wantSecure   bool
+	}{
+		{"https", "https://demo.example.com", "", http.SameSiteNoneMode, true},
+		{"https reuses the binding", "https://demo.example.com", "existing-binding", http.SameSiteNoneMode, true},
+		{"http", "http://localhost:8080", "", http.SameSiteLaxMode, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &deps.Resolver{Config: &config.Config{APIConfig: config.APIConfig{BaseURL: tt.baseURL}}}
+
+			r := httptest.NewRequest(http.MethodGet, "/login/google", nil)
+			if tt.existing != "" {
+				r.AddCookie(&http.Cookie{Name: loginBindingCookieName, Value: tt.existing})
+			}
+			w := httptest.NewRecorder()
+
+			binding, err := ensureLoginBindingCookie(resolver, w, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.existing != "" && binding != tt.existing {
+				t.Errorf("binding = %q, want the existing %q", binding, tt.existing)
+			}
+			if binding == "" {
+				t.Fatal("empty binding")
+			}
+
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("set %d cookies, want 1", len(cookies))
+			}
+			cookie := cookies[0]
+			if cookie.Name != loginBindingCookieName || cookie.Value != binding {
+				t.Errorf("cookie = %s=%s, want %s=%s", cookie.Name, cookie.Value, loginBindingCookieName, binding)
+			}
+			if cookie.SameSite != tt.wantSameSite || cookie.Secure != tt.wantSecure || !cookie.HttpOnly {
+				t.Errorf("cookie SameSite=%v Secure=%v HttpOnly=%v, want SameSite=%v Secure=%v HttpOnly=true",
+					cookie.SameSite, cookie.Secure, cookie.HttpOnly, tt.wantSameSite, tt.wantSecure)
+			}
+		})
+	}
+}
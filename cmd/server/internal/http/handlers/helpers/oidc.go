@@ -2,160 +2,206 @@ package helpers
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
 	"github.com/Nick-Anderssohn/oidc-demo/internal/oidc"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/provider"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/providertoken"
 	"github.com/Nick-Anderssohn/oidc-demo/internal/session"
 	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
-	"github.com/Nick-Anderssohn/oidc-demo/internal/util"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"golang.org/x/oauth2"
 )
 
-type OIDCConfig struct {
-	ClientID     string
-	ClientSecret string
-	RedirectURL  string
-	DiscoveryURL string
-	Scopes       []string
-}
-
 func RedirectToAuthorizationServer(
 	depResolver *deps.Resolver,
-	config *OIDCConfig,
+	idp *provider.Provider,
 	w http.ResponseWriter,
 	r *http.Request,
+	extraOptions ...oauth2.AuthCodeOption,
 ) {
-	oauthConfig, err := getOIDCConfig(config)
+	oauthConfig, err := idp.OAuth2Config(r.Context())
 
 	if err != nil {
 		http.Error(w, "Configuration error", http.StatusInternalServerError)
 		return
 	}
 
-	stateToken, err := util.GenerateSecureID()
+	loginTx, err := createLoginTransaction(depResolver, idp, w, r)
 	if err != nil {
-		log.Printf("could not generate state token")
-		http.Error(w, "Failed to generate state token", http.StatusInternalServerError)
+		log.Printf("Failed to create login transaction: %v", err)
+		http.Error(w, "Failed to create login transaction", http.StatusInternalServerError)
+		return
 	}
 
-	err = depResolver.Queries.InsertStateToken(r.Context(), stateToken)
-	if err != nil {
-		log.Printf("Failed to insert state token: %v", err)
-		http.Error(w, "Failed to insert state token", http.StatusInternalServerError)
-		return
+	// The configured parameters go first so that the protocol parameters
+	// below always win. Config validation also rejects the reserved ones.
+	var authOptions []oauth2.AuthCodeOption
+	for key, value := range idp.AuthParams {
+		authOptions = append(authOptions, oauth2.SetAuthURLParam(key, value))
+	}
+
+	authOptions = append(authOptions, oauth2.S256ChallengeOption(loginTx.CodeVerifier))
+
+	if idp.IsOIDC() {
+		authOptions = append(authOptions, oauth2.SetAuthURLParam("nonce", loginTx.Nonce))
+	}
+
+	if idp.ResponseMode != "" {
+		authOptions = append(authOptions, oauth2.SetAuthURLParam("response_mode", idp.ResponseMode))
+	}
+
+	if idp.IsOIDC() && idp.MaxAge > 0 {
+		maxAgeSeconds := strconv.FormatInt(int64(idp.MaxAge/time.Second), 10)
+		authOptions = append(authOptions, oauth2.SetAuthURLParam("max_age", maxAgeSeconds))
 	}
 
-	// We're just going to reuse the state token for the nonce too
-	// for this demo app. In a real app, you might want this to work
-	// differently depending on how/why you are using oauth2.
-	nonceOption := oauth2.SetAuthURLParam("nonce", stateToken)
+	authOptions = append(authOptions, extraOptions...)
 
-	authUrl := oauthConfig.AuthCodeURL(stateToken, nonceOption)
+	authUrl := oauthConfig.AuthCodeURL(loginTx.Token, authOptions...)
 
 	http.Redirect(w, r, authUrl, http.StatusFound)
 }
 
 func HandleOIDCCallback(
 	depResolver *deps.Resolver,
-	config *OIDCConfig,
+	idp *provider.Provider,
 	w http.ResponseWriter,
 	r *http.Request,
 ) {
-	err := validateState(depResolver, r)
+	stateRecord, err := consumeLoginTransaction(depResolver, r)
 	if err != nil {
 		log.Printf("State validation failed: %v", err)
 		http.Error(w, "State validation failed", http.StatusBadRequest)
 		return
 	}
 
-	oidcConfig, err := getOIDCConfig(config)
-	if err != nil {
-		log.Printf("Failed to get OIDC config: %v", err)
-		http.Error(w, "Failed to get OIDC config", http.StatusInternalServerError)
+	// A state issued for one provider must not be redeemed at another
+	// provider's callback.
+	if stateRecord.IdentityProviderID != idp.ID {
+		log.Printf("State was issued for provider %s, not %s", stateRecord.IdentityProviderID, idp.ID)
+		http.Error(w, "State validation failed", http.StatusBadRequest)
 		return
 	}
 
-	code := r.URL.Query().Get("code")
+	code := callbackParam(r, "code")
 	if code == "" {
 		log.Printf("Code parameter is missing")
 		http.Error(w, "Code parameter is missing", http.StatusBadRequest)
 		return
 	}
 
-	tokenResp, err := oidc.ExchangeCodeForToken(r.Context(), &oidcConfig, code)
+	var tokenResp oidc.TokenResponse
+	if idp.IsOIDC() {
+		tokenResp, err = exchangeOIDCCode(depResolver, idp, r.Context(), &stateRecord, code)
+	} else {
+		// Plain OAuth2 providers don't issue ID tokens, so the identity comes
+		// from their profile endpoint instead.
+		tokenResp, err = idp.ExchangeOAuth2Code(r.Context(), code, oauth2.VerifierOption(stateRecord.CodeVerifier))
+	}
 	if err != nil {
 		log.Printf("Failed to exchange code: %v", err)
 		http.Error(w, "Failed to exchange code", http.StatusInternalServerError)
 		return
 	}
 
-	nonce, ok := tokenResp.IDTokenPayload["nonce"].(string)
-	if !ok || nonce == "" {
-		log.Println("missing nonce")
-		http.Error(w, "missing nonce", http.StatusInternalServerError)
-		return
+	if r.Method == http.MethodPost {
+		mergeFormPostUser(r.PostFormValue("user"), tokenResp.IDTokenPayload)
 	}
 
-	if err = checkNonce(depResolver, r.Context(), nonce); err != nil {
-		log.Println("nonce already seen before! you trying a replay attack?!")
-		http.Error(w, "nonce already seen before! you trying a replay attack?!", http.StatusInternalServerError)
+	user, identity, err := upsertUserAndIdentity(depResolver, idp.ID, stateRecord.UserID, &tokenResp, r.Context())
+	if errors.Is(err, errUnverifiedEmailInUse) {
+		http.Error(w, "An account with this email already exists. Log in to it and link this provider from there.", http.StatusConflict)
 		return
 	}
-
-	user, err := upsertUserAndIdentity(depResolver, &tokenResp, r.Context())
 	if err != nil {
 		log.Printf("Failed to upsert user and identity: %v", err)
 		http.Error(w, "Failed to upsert user and identity", http.StatusInternalServerError)
 		return
 	}
 
+	sid, _ := tokenResp.IDTokenPayload[oidc.Sid].(string)
+	login := session.ProviderLogin{
+		IdentityID: identity.ID,
+		ProviderID: idp.ID,
+		SID:        sid,
+		IDToken:    tokenResp.IDToken,
+	}
+
 	sessionSVC := session.Service{Resolver: depResolver}
-	err = sessionSVC.SaveNewSessionCookie(r.Context(), user.ID, w)
+	err = sessionSVC.SaveNewSessionCookie(r.Context(), user.ID, login, w, r)
 	if err != nil {
 		log.Printf("Failed to save session cookie: %v", err)
 		http.Error(w, "Failed to save session cookie", http.StatusInternalServerError)
 	}
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	http.Redirect(w, r, stateRecord.ReturnTo, http.StatusFound)
 }
 
-func validateState(
+func exchangeOIDCCode(
 	depResolver *deps.Resolver,
-	r *http.Request,
-) error {
-	state := r.URL.Query().Get("state")
-
-	if state == "" {
-		return fmt.Errorf("state parameter is missing")
+	idp *provider.Provider,
+	ctx context.Context,
+	stateRecord *dal.DemoStateToken,
+	code string,
+) (oidc.TokenResponse, error) {
+	oidcConfig, err := idp.OIDCConfig(ctx)
+	if err != nil {
+		return oidc.TokenResponse{}, fmt.Errorf("failed to get OIDC config: %v", err)
 	}
 
-	_, err := depResolver.Queries.GetStateToken(r.Context(), state)
+	tokenResp, err := oidc.ExchangeCodeForToken(
+		ctx,
+		&oidcConfig,
+		code,
+		oauth2.VerifierOption(stateRecord.CodeVerifier),
+	)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return fmt.Errorf("invalid state token: %s", state)
-		} else {
-			return fmt.Errorf("failed to get state token: %v", err)
-		}
+		return oidc.TokenResponse{}, err
 	}
 
-	return nil
-}
+	nonce, ok := tokenResp.IDTokenPayload[oidc.Nonce].(string)
+	if !ok || nonce == "" {
+		return oidc.TokenResponse{}, fmt.Errorf("missing nonce")
+	}
 
-func checkNonce(depResolver *deps.Resolver, ctx context.Context, nonce string) error {
-	queries := depResolver.Queries
+	if err = checkNonce(depResolver, ctx, stateRecord, nonce); err != nil {
+		return oidc.TokenResponse{}, err
+	}
 
-	// We currently are using the state token to track what nonces we generated
-	_, err := queries.GetStateToken(ctx, nonce)
-	if err != nil {
+	// The ID token only has profile claims like name and picture for some
+	// providers and scopes, so fill them in from UserInfo when we can.
+	sub, _ := tokenResp.IDTokenPayload[oidc.Sub].(string)
+	userInfo, err := oidc.FetchUserInfo(ctx, &oidcConfig, tokenResp.AccessToken, sub)
+	if err != nil && !errors.Is(err, oidc.ErrUserInfoNotSupported) {
+		return oidc.TokenResponse{}, fmt.Errorf("failed to fetch userinfo: %v", err)
+	}
+	oidc.MergeUserInfo(tokenResp.IDTokenPayload, userInfo)
+
+	return tokenResp, nil
+}
+
+func checkNonce(
+	depResolver *deps.Resolver,
+	ctx context.Context,
+	stateRecord *dal.DemoStateToken,
+	nonce string,
+) error {
+	if subtle.ConstantTimeCompare([]byte(nonce), []byte(stateRecord.Nonce)) != 1 {
 		return fmt.Errorf("invalid nonce")
 	}
 
-	err = depResolver.Queries.InsertNonce(ctx, nonce)
+	err := depResolver.Queries.InsertNonce(ctx, nonce)
 	if err != nil {
 		return fmt.Errorf("nonce already seen. this could be a replay attack!")
 	}
@@ -163,67 +209,67 @@ func checkNonce(depResolver *deps.Resolver, ctx context.Context, nonce string) e
 	return nil
 }
 
-func getOIDCConfig(config *OIDCConfig) (oidc.Config, error) {
-	discoveryData, err := oidc.GetDiscoveryData(config.DiscoveryURL)
-	if err != nil {
-		return oidc.Config{}, err
+// errUnverifiedEmailInUse is returned when a first login with a provider
+// brings an email address that already belongs to a user, but the provider
+// doesn't vouch for it.
+var errUnverifiedEmailInUse = errors.New("email belongs to an existing user but is not verified")
+
+// emailVerified reports whether the claims say the provider verified the
+// email address. Apple sends email_verified as a string.
+func emailVerified(claims map[string]any) bool {
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		return verified
+	case string:
+		return verified == "true"
+	default:
+		return false
 	}
-
-	return oidc.Config{
-		Config: &oauth2.Config{
-			ClientID:     config.ClientID,
-			ClientSecret: config.ClientSecret,
-
-			Endpoint: oauth2.Endpoint{
-				AuthURL:  discoveryData.AuthorizationEndpoint,
-				TokenURL: discoveryData.TokenEndpoint,
-			},
-
-			RedirectURL: config.RedirectURL,
-			Scopes:      config.Scopes,
-		},
-		DiscoveryURL: config.DiscoveryURL,
-	}, nil
 }
 
+// upsertUserAndIdentity finds or creates the user the identity belongs to.
+// loggedInUserID is the user who was logged in when the login started, if
+// any, and the identity is linked to their account. It comes from the login
+// transaction rather than the request because browsers don't send the
+// session cookie with the cross-site POST of a form_post callback.
 func upsertUserAndIdentity(
 	depResolver *deps.Resolver,
+	providerID string,
+	loggedInUserID pgtype.UUID,
 	tokenResp *oidc.TokenResponse,
 	ctx context.Context,
-) (dal.DemoUser, error) {
+) (dal.DemoUser, dal.DemoIdentity, error) {
 	queries := depResolver.Queries
 
 	// Extract external ID from token payload
 	externalID, ok := tokenResp.IDTokenPayload["sub"].(string)
 	if !ok || externalID == "" {
-		return dal.DemoUser{}, fmt.Errorf("external ID not found in ID token payload")
+		return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("external ID not found in ID token payload")
 	}
 
 	// Check if a user exists with the given external ID
 	user, err := queries.GetUserByIdentityExternalID(ctx, dal.GetUserByIdentityExternalIDParams{
-		IdentityProviderID: dal.IdentityProviderIDGoogle,
+		IdentityProviderID: providerID,
 		ExternalID:         externalID,
 	})
 
 	existingUserFound := err == nil
 	if err != nil && err != pgx.ErrNoRows {
-		return dal.DemoUser{}, fmt.Errorf("failed to get user by external ID: %v", err)
+		return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("failed to get user by external ID: %v", err)
 	}
 
-	// Check if the user is logged in
-	loggedInUserID, err := session.UserIDFromContext(ctx)
-	userIsLoggedIn := err == nil
+	userIsLoggedIn := loggedInUserID.Valid
 
 	// If user is logged in, ensure the account is not already linked to another user
 	if userIsLoggedIn && existingUserFound && user.ID != loggedInUserID {
-		return dal.DemoUser{}, fmt.Errorf("account is already linked to another user")
+		return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("account is already linked to another user")
 	}
 
 	// Make sure to grab the user if they are logged in via another account
 	if userIsLoggedIn && !existingUserFound {
 		user, err = queries.GetUser(ctx, loggedInUserID)
 		if err != nil {
-			return dal.DemoUser{}, fmt.Errorf("failed to get logged-in user: %v", err)
+			return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("failed to get logged-in user: %v", err)
 		}
 	}
 
@@ -231,30 +277,100 @@ func upsertUserAndIdentity(
 	if !userIsLoggedIn && !existingUserFound {
 		email, ok := tokenResp.IDTokenPayload["email"].(string)
 		if !ok || email == "" {
-			return dal.DemoUser{}, fmt.Errorf("email not found in ID token payload")
+			return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("email not found in ID token payload")
 		}
 
-		user, err = queries.UpsertUserByEmail(ctx, email)
-		if err != nil {
-			return dal.DemoUser{}, fmt.Errorf("failed to upsert user: %v", err)
+		// Matching an existing user by email links this identity to their
+		// account, so only do it when the provider verified the address.
+		// Otherwise anyone who can put an arbitrary email in a token, such as
+		// an Entra tenant admin, could sign in as that user.
+		if emailVerified(tokenResp.IDTokenPayload) {
+			user, err = queries.UpsertUserByEmail(ctx, email)
+			if err != nil {
+				return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("failed to upsert user: %v", err)
+			}
+		} else {
+			user, err = queries.InsertUserByEmail(ctx, email)
+			if err == pgx.ErrNoRows {
+				return dal.DemoUser{}, dal.DemoIdentity{}, errUnverifiedEmailInUse
+			}
+			if err != nil {
+				return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("failed to insert user: %v", err)
+			}
 		}
 	}
 
 	// Marshal ID token payload
 	idTokenJSON, err := json.Marshal(tokenResp.IDTokenPayload)
 	if err != nil {
-		return dal.DemoUser{}, fmt.Errorf("failed to marshal ID token payload: %v", err)
+		return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("failed to marshal ID token payload: %v", err)
 	}
 
 	// Upsert identity record
-	if _, err := queries.UpsertIdentity(ctx, dal.UpsertIdentityParams{
+	identity, err := queries.UpsertIdentity(ctx, dal.UpsertIdentityParams{
 		UserID:             user.ID,
-		IdentityProviderID: dal.IdentityProviderIDGoogle,
+		IdentityProviderID: providerID,
 		ExternalID:         externalID,
-		MostRecentIDToken:  idTokenJSON,
+	})
+	if err != nil {
+		return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("failed to upsert identity: %v", err)
+	}
+
+	// The claims are encrypted with the identity ID as associated data, so
+	// they can only be decrypted in the row they were written to
+	encryptedIDToken, err := depResolver.Keyring.Encrypt(idTokenJSON, identity.ID.Bytes[:])
+	if err != nil {
+		return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("failed to encrypt ID token payload: %v", err)
+	}
+
+	if err := queries.SetIdentityClaims(ctx, dal.SetIdentityClaimsParams{
+		ID:                         identity.ID,
+		EncryptedMostRecentIDToken: encryptedIDToken,
 	}); err != nil {
-		return dal.DemoUser{}, fmt.Errorf("failed to upsert identity: %v", err)
+		return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("failed to save ID token payload: %v", err)
+	}
+
+	// Keep the provider's tokens so we can call its APIs on the user's behalf
+	tokenService := providertoken.Service{Resolver: depResolver}
+	if err := tokenService.Save(ctx, identity.ID, tokenResp.Token); err != nil {
+		return dal.DemoUser{}, dal.DemoIdentity{}, fmt.Errorf("failed to save tokens: %v", err)
+	}
+
+	return user, identity, nil
+}
+
+// formPostUser is the "user" parameter Apple adds to the form_post callback,
+// only on the first time a user authorizes the app.
+// https://developer.apple.com/documentation/signinwithapple/incorporating-sign-in-with-apple-into-other-platforms
+type formPostUser struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+}
+
+// mergeFormPostUser copies the user's name into the claims, since it is
+// never sent again and isn't part of the ID token. The parameter isn't
+// signed, so it is only used for the name and never overrides a claim
+// from the ID token.
+func mergeFormPostUser(rawUser string, claims map[string]any) {
+	if rawUser == "" {
+		return
+	}
+
+	var user formPostUser
+	if err := json.Unmarshal([]byte(rawUser), &user); err != nil {
+		log.Printf("Ignoring malformed user parameter: %v", err)
+		return
+	}
+
+	setIfMissing := func(claim, value string) {
+		if _, exists := claims[claim]; !exists && value != "" {
+			claims[claim] = value
+		}
 	}
 
-	return user, nil
+	setIfMissing("given_name", user.Name.FirstName)
+	setIfMissing("family_name", user.Name.LastName)
+	setIfMissing("name", strings.TrimSpace(user.Name.FirstName+" "+user.Name.LastName))
 }
@@ -0,0 +1,230 @@
+package providerauth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/Nick-Anderssohn/oidc-demo/cmd/server/internal/http/handlers/helpers"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/oidc"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/provider"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/session"
+	"github.com/go-chi/chi"
+	"golang.org/x/oauth2"
+)
+
+// Handlers serves the login and callback endpoints for every provider in
+// the registry. The provider is picked by the {provider} URL parameter.
+type Handlers struct {
+	DepResolver *deps.Resolver
+}
+
+type ProviderInfo struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+	LoginURL    string `json:"loginUrl"`
+}
+
+func (h *Handlers) RedirectToAuthorizationServer(w http.ResponseWriter, r *http.Request) {
+	idp, ok := h.providerFromURL(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	helpers.RedirectToAuthorizationServer(
+		h.DepResolver,
+		idp,
+		w,
+		r,
+	)
+}
+
+// RedirectByEmail sends the user to the provider their email address signs
+// in with, found through WebFinger. The email is passed on as a login_hint
+// so they don't have to type it again.
+func (h *Handlers) RedirectByEmail(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("email")
+	if email == "" {
+		http.Error(w, "email is required", http.StatusBadRequest)
+		return
+	}
+
+	idp, err := h.DepResolver.Providers.ForEmail(r.Context(), email)
+	if err != nil {
+		// Most domains don't support WebFinger, so this is usually the user's
+		// domain not being set up rather than something going wrong here.
+		log.Printf("Failed to find provider for email: %v", err)
+		http.Error(w, "No sign-in provider found for that email", http.StatusNotFound)
+		return
+	}
+
+	helpers.RedirectToAuthorizationServer(
+		h.DepResolver,
+		idp,
+		w,
+		r,
+		oauth2.SetAuthURLParam("login_hint", email),
+	)
+}
+
+func (h *Handlers) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	idp, ok := h.providerFromURL(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Only accept POSTed authorization responses from providers we asked
+	// to use form_post.
+	if r.Method == http.MethodPost && !idp.UsesFormPost() {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	helpers.HandleOIDCCallback(
+		h.DepResolver,
+		idp,
+		w,
+		r,
+	)
+}
+
+// BackChannelLogout receives the logout tokens a provider POSTs when the user
+// logs out there, or is signed out by an admin, and ends the matching
+// sessions.
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#BCRequest
+func (h *Handlers) BackChannelLogout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	idp, ok := h.providerFromURL(r)
+	if !ok || !idp.IsOIDC() {
+		http.NotFound(w, r)
+		return
+	}
+
+	logoutToken := r.PostFormValue("logout_token")
+	if logoutToken == "" {
+		http.Error(w, "logout_token is missing", http.StatusBadRequest)
+		return
+	}
+
+	oidcConfig, err := idp.OIDCConfig(r.Context())
+	if err != nil {
+		log.Printf("Failed to get OIDC config: %v", err)
+		http.Error(w, "Configuration error", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := oidc.ValidateLogoutToken(r.Context(), &oidcConfig, logoutToken)
+	if err != nil {
+		log.Printf("Invalid logout token from %s: %v", idp.ID, err)
+		http.Error(w, "Invalid logout token", http.StatusBadRequest)
+		return
+	}
+
+	sessionSVC := session.Service{Resolver: h.DepResolver}
+
+	deleted, err := sessionSVC.EndProviderSessions(r.Context(), idp.ID, token)
+	if errors.Is(err, session.ErrLogoutTokenReplayed) {
+		log.Printf("Replayed logout token from %s: %s", idp.ID, token.Jti)
+		http.Error(w, "Invalid logout token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to end sessions: %v", err)
+		http.Error(w, "Failed to end sessions", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Back-channel logout from %s ended %d sessions", idp.ID, deleted)
+	w.WriteHeader(http.StatusOK)
+}
+
+// frontChannelLogoutPage is what the provider's logout page loads in an
+// iframe. Its content doesn't matter.
+const frontChannelLogoutPage = `<!DOCTYPE html>
+<html><head><title>Logged out</title></head><body></body></html>
+`
+
+// FrontChannelLogout is loaded in an iframe by the provider's logout page,
+// for providers that support Front-Channel Logout but not Back-Channel
+// Logout. It ends the sessions that came from the provider session in the
+// sid parameter.
+// https://openid.net/specs/openid-connect-frontchannel-1_0.html#RPLogout
+func (h *Handlers) FrontChannelLogout(w http.ResponseWriter, r *http.Request) {
+	// The response must not be cached, or a later logout would not reach us.
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	idp, ok := h.providerFromURL(r)
+	if !ok || idp.FrontChannelLogout == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	iss := r.URL.Query().Get("iss")
+	sid := r.URL.Query().Get("sid")
+
+	// iss and sid come together or not at all.
+	if (iss == "") != (sid == "") || (idp.FrontChannelLogout.SessionRequired && sid == "") {
+		http.Error(w, "iss and sid are required", http.StatusBadRequest)
+		return
+	}
+
+	if iss != "" {
+		oidcConfig, err := idp.OIDCConfig(r.Context())
+		if err != nil {
+			log.Printf("Failed to get OIDC config: %v", err)
+			http.Error(w, "Configuration error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := oidc.ValidateFrontChannelLogoutIssuer(r.Context(), &oidcConfig, iss); err != nil {
+			log.Printf("Invalid front-channel logout from %s: %v", idp.ID, err)
+			http.Error(w, "Invalid issuer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sessionSVC := session.Service{Resolver: h.DepResolver}
+
+	deleted, err := sessionSVC.EndFrontChannelSessions(r.Context(), w, idp.ID, sid)
+	if err != nil {
+		log.Printf("Failed to end sessions: %v", err)
+		http.Error(w, "Failed to end sessions", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Front-channel logout from %s ended %d sessions", idp.ID, deleted)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if _, err := w.Write([]byte(frontChannelLogoutPage)); err != nil {
+		log.Printf("Failed to write front-channel logout page: %v", err)
+	}
+}
+
+// ListProviders lets the frontend render a login button per provider.
+func (h *Handlers) ListProviders(w http.ResponseWriter, r *http.Request) {
+	providers := []ProviderInfo{}
+	for _, idp := range h.DepResolver.Providers.All() {
+		providers = append(providers, ProviderInfo{
+			ID:          idp.ID,
+			DisplayName: idp.DisplayName,
+			LoginURL:    "/login/" + idp.ID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(providers); err != nil {
+		log.Printf("Failed to encode providers: %v", err)
+		http.Error(w, "Failed to encode providers", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *Handlers) providerFromURL(r *http.Request) (*provider.Provider, bool) {
+	return h.DepResolver.Providers.Get(chi.URLParam(r, "provider"))
+}
@@ -0,0 +1,175 @@
+package providerauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/config"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/oidc"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/provider"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/testdb"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/util"
+	"github.com/go-chi/chi"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const frontChannelProviderID = "frontchannel-test"
+
+// newFrontChannelRouter serves FrontChannelLogout for a provider whose
+// discovery document is served by a test server, and returns the provider's
+// issuer.
+func newFrontChannelRouter(t *testing.T, resolver *deps.Resolver, sessionRequired bool) (http.Handler, string) {
+	t.Helper()
+
+	var issuer string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(oidc.DiscoveryData{
+			Issuer:                           issuer,
+			AuthorizationEndpoint:            issuer + "/authorize",
+			TokenEndpoint:                    issuer + "/token",
+			JwksURI:                          issuer + "/jwks",
+			ResponseTypesSupported:           []string{"code"},
+			SubjectTypesSupported:            []string{"public"},
+			IDTokenSigningAlgValuesSupported: []string{oidc.RS256},
+		})
+	}))
+	t.Cleanup(srv.Close)
+	issuer = srv.URL
+
+	previousClient := oidc.HTTPClient
+	oidc.HTTPClient = *srv.Client()
+	t.Cleanup(func() { oidc.HTTPClient = previousClient })
+
+	providers, err := provider.NewRegistry(&config.Config{
+		DiscoveryConfig: config.DiscoveryConfig{DefaultTTL: time.Hour, MaxTTL: time.Hour},
+		Providers: []config.ProviderConfig{{
+			ID:                 frontChannelProviderID,
+			Kind:               config.ProviderKindOIDC,
+			DiscoveryURL:       issuer + "/.well-known/openid-configuration",
+			ClientID:           "client",
+			FrontChannelLogout: &config.FrontChannelLogoutConfig{SessionRequired: sessionRequired},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver.Providers = providers
+
+	h := &Handlers{DepResolver: resolver}
+	router := chi.NewRouter()
+	router.Get("/frontchannel-logout/{provider}", h.FrontChannelLogout)
+
+	return router, issuer
+}
+
+func frontChannelLogout(router http.Handler, providerID string, query url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/frontchannel-logout/"+providerID+"?"+query.Encode(), nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestFrontChannelLogoutRejects covers the requests that are turned away
+// before any session is looked up.
+func TestFrontChannelLogoutRejects(t *testing.T) {
+	tests := []struct {
+		name            string
+		providerID      string
+		sessionRequired bool
+		query           func(issuer string) url.Values
+		want            int
+	}{
+		{"unknown provider", "other", false, func(issuer string) url.Values {
+			return url.Values{"iss": {issuer}, "sid": {"sid"}}
+		}, http.StatusNotFound},
+		{"mismatched issuer", frontChannelProviderID, false, func(string) url.Values {
+			return url.Values{"iss": {"https://other.example.com"}, "sid": {"sid"}}
+		}, http.StatusBadRequest},
+		{"issuer with a trailing slash", frontChannelProviderID, false, func(issuer string) url.Values {
+			return url.Values{"iss": {issuer + "/"}, "sid": {"sid"}}
+		}, http.StatusBadRequest},
+		{"missing sid", frontChannelProviderID, false, func(issuer string) url.Values {
+			return url.Values{"iss": {issuer}}
+		}, http.StatusBadRequest},
+		{"missing iss", frontChannelProviderID, false, func(string) url.Values {
+			return url.Values{"sid": {"sid"}}
+		}, http.StatusBadRequest},
+		{"missing both when a session is required", frontChannelProviderID, true, func(string) url.Values {
+			return url.Values{}
+		}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, issuer := newFrontChannelRouter(t, &deps.Resolver{}, tt.sessionRequired)
+
+			rec := frontChannelLogout(router, tt.providerID, tt.query(issuer))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestFrontChannelLogoutEndsSessionsWithSid(t *testing.T) {
+	pool, queries := testdb.Connect(t)
+	ctx := context.Background()
+
+	resolver := &deps.Resolver{DBPool: pool, Queries: queries}
+	router, issuer := newFrontChannelRouter(t, resolver, true)
+
+	user := testdb.NewUser(t, queries)
+	identity := testdb.NewIdentity(t, queries, user.ID, frontChannelProviderID)
+
+	newSession := func(providerSid string) string {
+		t.Helper()
+		id, err := util.GenerateSecureID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := queries.InsertSession(ctx, dal.InsertSessionParams{
+			ID:                 id,
+			UserID:             user.ID,
+			IdentityID:         identity.ID,
+			IdentityProviderID: pgtype.Text{String: frontChannelProviderID, Valid: true},
+			ProviderSid:        pgtype.Text{String: providerSid, Valid: true},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	ended := newSession("sid-1")
+	other := newSession("sid-2")
+
+	// A request with the wrong issuer doesn't end anything.
+	rec := frontChannelLogout(router, frontChannelProviderID, url.Values{"iss": {"https://other.example.com"}, "sid": {"sid-1"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d with a mismatched issuer, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if _, err := queries.GetSession(ctx, ended); err != nil {
+		t.Fatalf("session was ended by a request with a mismatched issuer: %v", err)
+	}
+
+	rec = frontChannelLogout(router, frontChannelProviderID, url.Values{"iss": {issuer}, "sid": {"sid-1"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	if cacheControl := rec.Header().Get("Cache-Control"); cacheControl != "no-cache, no-store" {
+		t.Errorf("Cache-Control = %q", cacheControl)
+	}
+
+	if _, err := queries.GetSession(ctx, ended); err == nil {
+		t.Error("the session with the sid was not ended")
+	}
+	if _, err := queries.GetSession(ctx, other); err != nil {
+		t.Errorf("a session with another sid was ended: %v", err)
+	}
+}
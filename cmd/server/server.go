@@ -4,10 +4,18 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Nick-Anderssohn/oidc-demo/cmd/server/internal/http/handlers/api"
-	"github.com/Nick-Anderssohn/oidc-demo/cmd/server/internal/http/handlers/googleauth"
+	"github.com/Nick-Anderssohn/oidc-demo/cmd/server/internal/http/handlers/providerauth"
 	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/janitor"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/keyrotation"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/revocation"
 	"github.com/Nick-Anderssohn/oidc-demo/internal/session"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -20,7 +28,10 @@ type Message struct {
 func main() {
 	log.Println("Starting server...")
 
-	backgroundCtx := context.Background()
+	// Cancelled when the process is asked to stop, which shuts down the
+	// background jobs and the http server.
+	backgroundCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	resolver, err := deps.InitDepsResolver(backgroundCtx)
 	if err != nil {
@@ -28,6 +39,25 @@ func main() {
 	}
 	defer resolver.Close()
 
+	if err := resolver.Providers.Seed(backgroundCtx, resolver.Queries); err != nil {
+		panic(err)
+	}
+
+	if err := resolver.Providers.Discover(backgroundCtx); err != nil {
+		panic(err)
+	}
+
+	// ID token claims stored before they were encrypted are encrypted now, so
+	// the migration that drops the plaintext column can run.
+	reencrypter := keyrotation.Reencrypter{Resolver: &resolver, BatchSize: 500}
+	legacyClaims, err := reencrypter.EncryptLegacyClaims(backgroundCtx)
+	if err != nil {
+		panic(err)
+	}
+	if legacyClaims > 0 {
+		log.Printf("encrypted %d legacy identity claims", legacyClaims)
+	}
+
 	router := chi.NewRouter()
 
 	router.Use(middleware.Logger)
@@ -51,14 +81,78 @@ func main() {
 
 		r.Get("/me", apiHandlers.Me)
 		r.Delete("/me", apiHandlers.DeleteMe)
+		r.Delete("/identities/{id}", apiHandlers.DeleteIdentity)
+
+		r.Get("/sessions", apiHandlers.ListSessions)
+		r.Delete("/sessions/others", apiHandlers.DeleteOtherSessions)
+		r.Delete("/sessions/{id}", apiHandlers.DeleteSession)
 	})
 
+	var backgroundJobs sync.WaitGroup
+	startJanitor(backgroundCtx, &resolver, &backgroundJobs)
+	startRevocationWorker(backgroundCtx, &resolver, &backgroundJobs)
+
 	port := resolver.Config.APIConfig.Port
+	server := &http.Server{
+		Addr:    ":" + port,
+		Handler: router,
+	}
+
+	go func() {
+		<-backgroundCtx.Done()
+		log.Println("Shutting down server...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down server cleanly: %v", err)
+		}
+	}()
 
 	log.Println("Server is running on http://localhost:" + port)
-	if err := http.ListenAndServe(":"+port, router); err != nil {
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+
+	backgroundJobs.Wait()
+	log.Println("Server stopped")
+}
+
+func startJanitor(ctx context.Context, resolver *deps.Resolver, wg *sync.WaitGroup) {
+	janitorCfg := resolver.Config.JanitorConfig
+	sessionCfg := resolver.Config.SessionConfig
+
+	j := janitor.Janitor{
+		Resolver:           resolver,
+		Interval:           janitorCfg.Interval,
+		BatchSize:          janitorCfg.BatchSize,
+		NonceRetention:     janitorCfg.NonceRetention,
+		SessionLifetime:    sessionCfg.AbsoluteLifetime,
+		SessionIdleTimeout: sessionCfg.IdleTimeout,
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		j.Run(ctx)
+	}()
+}
+
+func startRevocationWorker(ctx context.Context, resolver *deps.Resolver, wg *sync.WaitGroup) {
+	revocationCfg := resolver.Config.RevocationConfig
+
+	worker := revocation.Worker{
+		Resolver:    resolver,
+		Interval:    revocationCfg.Interval,
+		BatchSize:   revocationCfg.BatchSize,
+		MaxAttempts: revocationCfg.MaxAttempts,
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		worker.Run(ctx)
+	}()
 }
 
 func contentTypeJsonMiddleware(next http.Handler) http.Handler {
@@ -69,20 +163,34 @@ func contentTypeJsonMiddleware(next http.Handler) http.Handler {
 }
 
 func registerAuthEndpoints(router *chi.Mux, apiHandlers *api.Handlers) {
-	googleHandlers := googleauth.Handlers{
+	providerHandlers := providerauth.Handlers{
 		DepResolver: apiHandlers.DepResolver,
 	}
 
+	// Lists the configured providers so the frontend can offer them
+	router.Get("/api/providers", providerHandlers.ListProviders)
+
 	// Endpoints that handle redirecting to the authorization server
 	router.Route("/login", func(r chi.Router) {
-		r.Get("/google", googleHandlers.RedirectToAuthorizationServer)
+		// /login?email= finds the provider through WebFinger
+		r.Get("/", providerHandlers.RedirectByEmail)
+		r.Get("/{provider}", providerHandlers.RedirectToAuthorizationServer)
 	})
 
-	// Endpoints that handle the callback from the authorization server
+	// Endpoints that handle the callback from the authorization server.
+	// Providers using response_mode=form_post POST to them.
 	router.Route("/callbacks", func(r chi.Router) {
-		r.Get("/google", googleHandlers.HandleCallback)
+		r.Get("/{provider}", providerHandlers.HandleCallback)
+		r.Post("/{provider}", providerHandlers.HandleCallback)
 	})
 
 	// Endpoints that handle logging out
 	router.Get("/logout", apiHandlers.Logout)
+	router.Get(session.LogoutCallbackPath, apiHandlers.LogoutCallback)
+
+	// Providers POST here when the user logs out with them
+	router.Post("/backchannel-logout/{provider}", providerHandlers.BackChannelLogout)
+
+	// Providers load this in an iframe when the user logs out with them
+	router.Get("/frontchannel-logout/{provider}", providerHandlers.FrontChannelLogout)
 }
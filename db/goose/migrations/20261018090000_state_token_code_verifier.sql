@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+alter table demo.state_token add column code_verifier text;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table demo.state_token drop column code_verifier;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Any in-flight logins were created without the columns below and can't be
+-- completed anymore, so there's nothing worth keeping.
+delete from demo.state_token;
+
+alter table demo.state_token
+    alter column code_verifier set not null,
+    add column nonce text not null,
+    add column identity_provider_id text not null references demo.identity_provider(id) on delete cascade,
+    add column return_to text not null default '/',
+    add column browser_binding text not null,
+    add column expires_at timestamp with time zone not null;
+
+create index idx_state_token_expires_at on demo.state_token(expires_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index demo.idx_state_token_expires_at;
+
+alter table demo.state_token
+    alter column code_verifier drop not null,
+    drop column nonce,
+    drop column identity_provider_id,
+    drop column return_to,
+    drop column browser_binding,
+    drop column expires_at;
+-- +goose StatementEnd
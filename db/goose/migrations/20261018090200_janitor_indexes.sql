@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+create index idx_nonce_created_at on demo.nonce(created_at);
+create index idx_session_created_at on demo.session(created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index demo.idx_nonce_created_at;
+drop index demo.idx_session_created_at;
+-- +goose StatementEnd
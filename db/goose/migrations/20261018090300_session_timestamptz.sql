@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Session expiry is computed from these columns, so they need to be
+-- unambiguous regardless of the server's time zone.
+alter table demo.session
+    alter column created_at type timestamp with time zone,
+    alter column updated_at type timestamp with time zone;
+
+create index idx_session_updated_at on demo.session(updated_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index demo.idx_session_updated_at;
+
+alter table demo.session
+    alter column created_at type timestamp without time zone,
+    alter column updated_at type timestamp without time zone;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- public_id identifies a session in the API without exposing the session
+-- cookie value.
+alter table demo.session
+    add column public_id uuid not null default uuid_generate_v4() unique,
+    add column ip_address text,
+    add column user_agent text;
+
+create index idx_session_user_id on demo.session(user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index demo.idx_session_user_id;
+
+alter table demo.session
+    drop column public_id,
+    drop column ip_address,
+    drop column user_agent;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Sessions are now looked up by the SHA-256 of the cookie value. Hashing the
+-- existing IDs in place keeps everyone logged in. The trigger is disabled so
+-- that updated_at keeps tracking when each session was last used.
+alter table demo.session disable trigger session_set_updated_at;
+
+update demo.session
+set id = encode(sha256(convert_to(id, 'UTF8')), 'hex');
+
+alter table demo.session enable trigger session_set_updated_at;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+-- The hashes can't be reversed, so the existing sessions have to go.
+delete from demo.session;
+-- +goose StatementEnd
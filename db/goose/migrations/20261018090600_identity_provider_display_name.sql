@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Identity providers are seeded from configuration on startup.
+alter table demo.identity_provider add column display_name text not null default '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table demo.identity_provider drop column display_name;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Tokens issued by the identity provider, encrypted by the application.
+create table demo.identity_token (
+    identity_id uuid primary key references demo.identity(id) on delete cascade,
+    encrypted_access_token bytea not null,
+    encrypted_refresh_token bytea,
+    token_type text not null,
+    expires_at timestamptz,
+    needs_reconsent boolean not null default false,
+    created_at timestamptz default now(),
+    updated_at timestamptz default now()
+);
+create trigger identity_token_set_updated_at
+before update on demo.identity_token
+for each row
+execute function set_updated_at();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table demo.identity_token;
+-- +goose StatementEnd
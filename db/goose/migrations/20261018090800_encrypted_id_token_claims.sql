@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The claims are encrypted by the application. most_recent_id_token is only
+-- kept until the reencrypt command has moved existing rows over.
+alter table demo.identity add column encrypted_most_recent_id_token bytea;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table demo.identity drop column encrypted_most_recent_id_token;
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Tokens waiting to be revoked at the provider. Rows are deleted once the
+-- provider confirms, or after too many failed attempts.
+create table demo.token_revocation (
+    id uuid primary key,
+    identity_provider_id text not null references demo.identity_provider(id) on delete cascade,
+    encrypted_token bytea not null,
+    token_type_hint text not null,
+    attempts integer not null default 0,
+    next_attempt_at timestamptz not null default now(),
+    last_error text,
+    created_at timestamptz default now(),
+    updated_at timestamptz default now()
+);
+create trigger token_revocation_set_updated_at
+before update on demo.token_revocation
+for each row
+execute function set_updated_at();
+
+create index idx_token_revocation_next_attempt_at on demo.token_revocation(next_attempt_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop table demo.token_revocation;
+-- +goose StatementEnd
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+-- What each session was logged in with, so that logging out can also end the
+-- user's session at the provider. The ID token is encrypted by the
+-- application.
+alter table demo.session
+    add column identity_id uuid references demo.identity(id) on delete set null,
+    add column identity_provider_id text references demo.identity_provider(id) on delete set null,
+    add column provider_sid text,
+    add column encrypted_id_token bytea;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table demo.session
+    drop column identity_id,
+    drop column identity_provider_id,
+    drop column provider_sid,
+    drop column encrypted_id_token;
+-- +goose StatementEnd
@@ -0,0 +1,24 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The jti of every back-channel logout token we accepted, so that a replayed
+-- token is rejected.
+create table demo.logout_token (
+    identity_provider_id text not null references demo.identity_provider(id) on delete cascade,
+    jti text not null,
+    created_at timestamptz default now(),
+
+    primary key (identity_provider_id, jti)
+);
+create index idx_logout_token_created_at on demo.logout_token(created_at);
+
+-- Back-channel logout looks sessions up by the provider's sid or by identity.
+create index idx_session_provider_sid on demo.session(identity_provider_id, provider_sid);
+create index idx_session_identity_id on demo.session(identity_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop index demo.idx_session_identity_id;
+drop index demo.idx_session_provider_sid;
+drop table demo.logout_token;
+-- +goose StatementEnd
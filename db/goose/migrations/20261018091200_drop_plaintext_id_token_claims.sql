@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The plaintext claims are encrypted by the server at startup, or by the
+-- reencrypt command. Refuse to drop any that haven't been, rather than lose
+-- them.
+do $$
+begin
+    if exists (select 1 from demo.identity where most_recent_id_token is not null) then
+        raise exception 'demo.identity still has plaintext ID token claims. Start the server or run cmd/reencrypt once, then migrate again.';
+    end if;
+end
+$$;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+alter table demo.identity drop column most_recent_id_token;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table demo.identity add column most_recent_id_token jsonb;
+-- +goose StatementEnd
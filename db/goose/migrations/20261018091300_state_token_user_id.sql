@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The user who was logged in when the login started, if any. Linking a new
+-- provider goes by this instead of the session cookie, which browsers don't
+-- send with the cross-site POST of a form_post callback.
+alter table demo.state_token
+    add column user_id uuid references demo."user"(id) on delete cascade;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+alter table demo.state_token
+    drop column user_id;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- updated_at is when the session was last used, and idle sessions expire by
+-- it. Re-encrypting a session's ID token under a new key isn't use, so it
+-- must not bump updated_at and revive a session that has gone idle.
+drop trigger session_set_updated_at on demo.session;
+create trigger session_set_updated_at
+before update on demo.session
+for each row
+when (new.encrypted_id_token is not distinct from old.encrypted_id_token)
+execute function set_updated_at();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+drop trigger session_set_updated_at on demo.session;
+create trigger session_set_updated_at
+before update on demo.session
+for each row
+execute function set_updated_at();
+-- +goose StatementEnd
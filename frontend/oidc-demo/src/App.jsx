@@ -7,6 +7,7 @@ import { useEffect } from 'react'
 function App() {
   const [userData, setUserData] = useState(null)
   const [loggedIn, setLoggedIn] = useState(false)
+  const [providers, setProviders] = useState([])
 
   useEffect(() => {
     fetch('/private/api/me', {credentials: 'include'})
@@ -21,6 +22,15 @@ function App() {
       })
   }, [])
 
+  useEffect(() => {
+    fetch('/api/providers')
+      .then(response => response.json())
+      .then(data => setProviders(data))
+      .catch(error => {
+        console.error('Error fetching /api/providers:', error)
+      })
+  }, [])
+
   var googleIdentityExists = userData &&
           Array.isArray(userData.identities) &&
             userData.identities.some(
@@ -42,9 +52,11 @@ function App() {
         {loggedIn && <h2>Logged in! Link another account:</h2>}
         {!loggedIn && <h2>Not logged in! Please log in or create an account via:</h2>}
         {!loggedIn && <p>Don't worry, you can hard delete your data from this database whenever you want.</p>}
-        <button onClick={() => window.location.href = '/login/google'}>
-            Google
-        </button>
+        {providers.map((provider) => (
+          <button key={provider.id} onClick={() => window.location.href = provider.loginUrl}>
+            {provider.displayName}
+          </button>
+        ))}
         <hr style={{ margin: '5px 0' }} />
         {userData && (
           <div className="user-info">
@@ -4,14 +4,27 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	APIConfig        APIConfig
-	PostgresConfig   PostgresConfig
-	GoogleOIDCConfig GoogleOIDCConfig
+	APIConfig      APIConfig
+	PostgresConfig PostgresConfig
+	Providers      []ProviderConfig
+	IDTokenConfig  IDTokenConfig
+	JanitorConfig  JanitorConfig
+	SessionConfig  SessionConfig
+	TokenConfig    TokenConfig
+
+	RevocationConfig RevocationConfig
+
+	EncryptionConfig EncryptionConfig
+
+	DiscoveryConfig DiscoveryConfig
 }
 
 type APIConfig struct {
@@ -27,9 +40,76 @@ type PostgresConfig struct {
 	DbName   string
 }
 
-type GoogleOIDCConfig struct {
-	ClientID     string
-	ClientSecret string
+// IDTokenConfig controls how strictly the time based claims of ID tokens
+// are validated.
+type IDTokenConfig struct {
+	ClockSkew      time.Duration
+	MaxIssuedAtAge time.Duration
+}
+
+// JanitorConfig controls the background job that deletes expired state
+// tokens, nonces and sessions.
+type JanitorConfig struct {
+	Interval  time.Duration
+	BatchSize int32
+
+	// NonceRetention is how long used nonces and logout token IDs are
+	// remembered for replay detection. It should comfortably exceed the
+	// lifetime of an ID token.
+	NonceRetention time.Duration
+}
+
+// SessionConfig controls how long sessions stay valid.
+type SessionConfig struct {
+	// AbsoluteLifetime is how long a session lasts after it is created, no
+	// matter how active it is.
+	AbsoluteLifetime time.Duration
+
+	// IdleTimeout ends a session that hasn't been used for this long.
+	IdleTimeout time.Duration
+
+	// TouchInterval throttles how often a session's last-seen time is
+	// written, so we don't write to the database on every request.
+	TouchInterval time.Duration
+}
+
+// TokenConfig controls how the tokens issued by identity providers are
+// refreshed.
+type TokenConfig struct {
+	// RefreshBefore is how long before an access token expires it is
+	// refreshed.
+	RefreshBefore time.Duration
+}
+
+// RevocationConfig controls the background job that revokes provider tokens
+// when a user deletes their account or unlinks an identity.
+type RevocationConfig struct {
+	// Interval is how often the queue is checked, and the delay before the
+	// first retry. Each further retry waits twice as long.
+	Interval  time.Duration
+	BatchSize int32
+
+	// MaxAttempts is how many times a revocation is tried before giving up.
+	MaxAttempts int32
+}
+
+// DiscoveryConfig controls how long providers' discovery documents are
+// cached for.
+type DiscoveryConfig struct {
+	// DefaultTTL is used when the provider doesn't say how long the document
+	// may be cached for.
+	DefaultTTL time.Duration
+
+	// MinTTL and MaxTTL bound whatever the provider says.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+
+	// RefreshAhead is how long before a document expires it is refetched in
+	// the background.
+	RefreshAhead time.Duration
+
+	// InsecureHosts may use plain http. Only meant for development.
+	InsecureHosts []string
 }
 
 func (c *PostgresConfig) ConnectionString() string {
@@ -55,6 +135,107 @@ func LoadConfig() (Config, error) {
 	log.Println("configured for base url: " + baseURL)
 	log.Println("configured for port " + port)
 
+	providersFile := os.Getenv("OIDC_DEMO_PROVIDERS_FILE")
+	if providersFile == "" {
+		providersFile = "providers.json"
+	}
+
+	providers, err := loadProviders(providersFile)
+	if err != nil {
+		return Config{}, err
+	}
+
+	clockSkew, err := durationFromEnv("OIDC_DEMO_CLOCK_SKEW", time.Minute)
+	if err != nil {
+		return Config{}, err
+	}
+
+	maxIssuedAtAge, err := durationFromEnv("OIDC_DEMO_ID_TOKEN_MAX_IAT_AGE", 10*time.Minute)
+	if err != nil {
+		return Config{}, err
+	}
+
+	janitorInterval, err := positiveDurationFromEnv("OIDC_DEMO_JANITOR_INTERVAL", 15*time.Minute)
+	if err != nil {
+		return Config{}, err
+	}
+
+	janitorBatchSize, err := intFromEnv("OIDC_DEMO_JANITOR_BATCH_SIZE", 1000)
+	if err != nil {
+		return Config{}, err
+	}
+
+	nonceRetention, err := durationFromEnv("OIDC_DEMO_NONCE_RETENTION", 24*time.Hour)
+	if err != nil {
+		return Config{}, err
+	}
+
+	sessionLifetime, err := durationFromEnv("OIDC_DEMO_SESSION_LIFETIME", 24*time.Hour)
+	if err != nil {
+		return Config{}, err
+	}
+
+	sessionIdleTimeout, err := durationFromEnv("OIDC_DEMO_SESSION_IDLE_TIMEOUT", 2*time.Hour)
+	if err != nil {
+		return Config{}, err
+	}
+
+	sessionTouchInterval, err := durationFromEnv("OIDC_DEMO_SESSION_TOUCH_INTERVAL", time.Minute)
+	if err != nil {
+		return Config{}, err
+	}
+
+	revocationInterval, err := positiveDurationFromEnv("OIDC_DEMO_REVOCATION_INTERVAL", time.Minute)
+	if err != nil {
+		return Config{}, err
+	}
+
+	revocationBatchSize, err := intFromEnv("OIDC_DEMO_REVOCATION_BATCH_SIZE", 100)
+	if err != nil {
+		return Config{}, err
+	}
+
+	revocationMaxAttempts, err := intFromEnv("OIDC_DEMO_REVOCATION_MAX_ATTEMPTS", 10)
+	if err != nil {
+		return Config{}, err
+	}
+
+	keks, err := loadKEKs()
+	if err != nil {
+		return Config{}, err
+	}
+
+	tokenRefreshBefore, err := durationFromEnv("OIDC_DEMO_TOKEN_REFRESH_BEFORE", time.Minute)
+	if err != nil {
+		return Config{}, err
+	}
+
+	discoveryDefaultTTL, err := durationFromEnv("OIDC_DEMO_DISCOVERY_DEFAULT_TTL", time.Hour)
+	if err != nil {
+		return Config{}, err
+	}
+
+	discoveryMinTTL, err := durationFromEnv("OIDC_DEMO_DISCOVERY_MIN_TTL", time.Minute)
+	if err != nil {
+		return Config{}, err
+	}
+
+	discoveryMaxTTL, err := durationFromEnv("OIDC_DEMO_DISCOVERY_MAX_TTL", 24*time.Hour)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if discoveryMinTTL > discoveryMaxTTL {
+		return Config{}, fmt.Errorf("OIDC_DEMO_DISCOVERY_MIN_TTL must not exceed OIDC_DEMO_DISCOVERY_MAX_TTL")
+	}
+
+	discoveryRefreshAhead, err := durationFromEnv("OIDC_DEMO_DISCOVERY_REFRESH_AHEAD", 5*time.Minute)
+	if err != nil {
+		return Config{}, err
+	}
+
+	discoveryInsecureHosts := listFromEnv("OIDC_DEMO_DISCOVERY_INSECURE_HOSTS")
+
 	return Config{
 		APIConfig: APIConfig{
 			BaseURL: baseURL,
@@ -67,9 +248,93 @@ func LoadConfig() (Config, error) {
 			Password: os.Getenv("POSTGRES_PASSWORD"),
 			DbName:   os.Getenv("POSTGRES_DB"),
 		},
-		GoogleOIDCConfig: GoogleOIDCConfig{
-			ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
-			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+		Providers: providers,
+		IDTokenConfig: IDTokenConfig{
+			ClockSkew:      clockSkew,
+			MaxIssuedAtAge: maxIssuedAtAge,
+		},
+		JanitorConfig: JanitorConfig{
+			Interval:       janitorInterval,
+			BatchSize:      janitorBatchSize,
+			NonceRetention: nonceRetention,
+		},
+		SessionConfig: SessionConfig{
+			AbsoluteLifetime: sessionLifetime,
+			IdleTimeout:      sessionIdleTimeout,
+			TouchInterval:    sessionTouchInterval,
+		},
+		TokenConfig: TokenConfig{
+			RefreshBefore: tokenRefreshBefore,
+		},
+		RevocationConfig: RevocationConfig{
+			Interval:    revocationInterval,
+			BatchSize:   revocationBatchSize,
+			MaxAttempts: revocationMaxAttempts,
+		},
+		EncryptionConfig: EncryptionConfig{
+			KEKs: keks,
+		},
+		DiscoveryConfig: DiscoveryConfig{
+			DefaultTTL:   discoveryDefaultTTL,
+			MinTTL:       discoveryMinTTL,
+			MaxTTL:       discoveryMaxTTL,
+			RefreshAhead: discoveryRefreshAhead,
+
+			InsecureHosts: discoveryInsecureHosts,
 		},
 	}, nil
 }
+
+// durationFromEnv parses an env var such as "30s" or "5m", falling back to
+// defaultValue if it isn't set.
+func durationFromEnv(name string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration for %s: %w", name, err)
+	}
+	return duration, nil
+}
+
+// listFromEnv splits a comma separated env var, ignoring empty items.
+func listFromEnv(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// positiveDurationFromEnv is durationFromEnv for durations that must be
+// greater than zero, such as the interval of a time.Ticker.
+func positiveDurationFromEnv(name string, defaultValue time.Duration) (time.Duration, error) {
+	duration, err := durationFromEnv(name, defaultValue)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got %s", name, duration)
+	}
+	return duration, nil
+}
+
+// intFromEnv parses an env var as a positive int32, falling back to
+// defaultValue if it isn't set.
+func intFromEnv(name string, defaultValue int32) (int32, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("invalid positive integer for %s: %q", name, value)
+	}
+	return int32(parsed), nil
+}
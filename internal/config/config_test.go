@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPositiveDurationFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"unset", "", 15 * time.Minute, false},
+		{"set", "30s", 30 * time.Second, false},
+		{"zero", "0s", 0, true},
+		{"bare zero", "0", 0, true},
+		{"negative", "-1m", 0, true},
+		{"invalid", "soon", 0, true},
+	}
+
+	for _, name := range []string{"OIDC_DEMO_JANITOR_INTERVAL", "OIDC_DEMO_REVOCATION_INTERVAL"} {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				t.Setenv(name, tt.value)
+
+				got, err := positiveDurationFromEnv(name, 15*time.Minute)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("positiveDurationFromEnv() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if got != tt.want {
+					t.Errorf("positiveDurationFromEnv() = %s, want %s", got, tt.want)
+				}
+			})
+		}
+	}
+}
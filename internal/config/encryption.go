@@ -0,0 +1,69 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// EncryptionConfig holds the key-encryption keys (KEKs) that protect
+// provider tokens and ID token claims at rest.
+type EncryptionConfig struct {
+	// KEKs are 32 byte AES keys, keyed by version. The highest version is
+	// used to encrypt new values; older ones are only kept for decrypting
+	// until the reencrypt command has been run.
+	KEKs map[uint32][]byte
+}
+
+// loadKEKs reads the KEKs from OIDC_DEMO_KEKS_FILE if it is set, otherwise
+// from OIDC_DEMO_KEKS. Both hold a comma separated list of
+// version:base64-key pairs, e.g. "1:c2VjcmV0...,2:bW9yZS...".
+func loadKEKs() (map[uint32][]byte, error) {
+	source := "OIDC_DEMO_KEKS"
+	value := os.Getenv("OIDC_DEMO_KEKS")
+
+	if path := os.Getenv("OIDC_DEMO_KEKS_FILE"); path != "" {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key-encryption keys file: %w", err)
+		}
+		source = path
+		value = string(contents)
+	}
+
+	keks := map[uint32][]byte{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		versionStr, encodedKey, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s: expected version:key, got %q", source, entry)
+		}
+
+		version, err := strconv.ParseUint(versionStr, 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("%s: invalid key version %q", source, versionStr)
+		}
+		if _, exists := keks[uint32(version)]; exists {
+			return nil, fmt.Errorf("%s: duplicate key version %d", source, version)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("%s: key version %d must be 32 base64 encoded bytes", source, version)
+		}
+
+		keks[uint32(version)] = key
+	}
+
+	if len(keks) == 0 {
+		return nil, fmt.Errorf("OIDC_DEMO_KEKS or OIDC_DEMO_KEKS_FILE is required")
+	}
+
+	return keks, nil
+}
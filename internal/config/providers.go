@@ -0,0 +1,268 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+)
+
+// Kinds of identity providers.
+const (
+	// ProviderKindOIDC is an OpenID Connect provider. This is the default.
+	ProviderKindOIDC = "oidc"
+
+	// ProviderKindOAuth2 is a plain OAuth2 provider, like GitHub, that
+	// doesn't issue ID tokens. Who the user is comes from a profile endpoint.
+	ProviderKindOAuth2 = "oauth2"
+)
+
+// ProviderConfig describes an identity provider users can log in with.
+// Providers are loaded from a JSON file so that new ones can be added
+// without code changes. String values may reference env vars, e.g.
+// "${GOOGLE_CLIENT_SECRET}", so that secrets don't have to live in the file.
+type ProviderConfig struct {
+	// ID is used in URLs (/login/{id}, /callbacks/{id}) and is stored in
+	// demo.identity_provider. It must never change once users have linked
+	// accounts with the provider.
+	ID           string   `json:"id"`
+	Kind         string   `json:"kind,omitempty"`
+	DisplayName  string   `json:"displayName"`
+	DiscoveryURL string   `json:"discoveryUrl"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes"`
+
+	// AuthParams are extra query parameters added to the authorization
+	// request, e.g. {"prompt": "select_account"}.
+	AuthParams map[string]string `json:"authParams,omitempty"`
+
+	// MaxAgeSeconds, if set, is sent as max_age and enforced against the
+	// auth_time claim.
+	MaxAgeSeconds int64 `json:"maxAgeSeconds,omitempty"`
+
+	// AllowedTenantIDs limits which tenants of a multi-tenant provider
+	// (e.g. Entra ID's common or organizations endpoint) can sign in.
+	AllowedTenantIDs []string `json:"allowedTenantIds,omitempty"`
+
+	// ResponseMode is sent as response_mode. With "form_post" the provider
+	// POSTs the authorization response to the callback instead of
+	// redirecting with a query string.
+	ResponseMode string `json:"responseMode,omitempty"`
+
+	// ClientSecretJWT is used instead of ClientSecret for providers that want
+	// the client secret to be a signed JWT, like Sign in with Apple.
+	ClientSecretJWT *ClientSecretJWTConfig `json:"clientSecretJwt,omitempty"`
+
+	// OAuth2 holds the settings of providers of kind "oauth2".
+	OAuth2 *OAuth2ProviderConfig `json:"oauth2,omitempty"`
+
+	// FrontChannelLogout enables /frontchannel-logout/{id} for providers
+	// that only support OpenID Connect Front-Channel Logout.
+	FrontChannelLogout *FrontChannelLogoutConfig `json:"frontChannelLogout,omitempty"`
+}
+
+// FrontChannelLogoutConfig mirrors how the front-channel logout URI is
+// registered with the provider.
+type FrontChannelLogoutConfig struct {
+	// SessionRequired means the provider was registered with
+	// frontchannel_logout_session_required, so every request must carry
+	// iss and sid.
+	SessionRequired bool `json:"sessionRequired,omitempty"`
+}
+
+// OAuth2ProviderConfig describes a plain OAuth2 provider. Since there is no
+// discovery document, the endpoints have to be configured by hand.
+type OAuth2ProviderConfig struct {
+	AuthorizationURL string `json:"authorizationUrl"`
+	TokenURL         string `json:"tokenUrl"`
+
+	// ProfileURL returns a JSON object describing the user.
+	ProfileURL string `json:"profileUrl"`
+
+	// EmailsURL optionally returns the user's email addresses, in the shape
+	// of GitHub's /user/emails. The primary, verified one is used.
+	EmailsURL string `json:"emailsUrl,omitempty"`
+
+	// RevocationURL is the provider's RFC 7009 token revocation endpoint,
+	// if it has one.
+	RevocationURL string `json:"revocationUrl,omitempty"`
+
+	// SubjectField is the profile field with the user's stable ID.
+	// Defaults to "id".
+	SubjectField string `json:"subjectField,omitempty"`
+
+	// EmailField is the profile field with the user's email, used if there
+	// is no EmailsURL. Defaults to "email".
+	EmailField string `json:"emailField,omitempty"`
+}
+
+// ClientSecretJWTConfig describes how to mint a client secret JWT.
+type ClientSecretJWTConfig struct {
+	// Issuer is the iss claim. For Apple this is the team ID.
+	Issuer string `json:"issuer"`
+
+	// KeyID is the ID of the signing key registered with the provider.
+	KeyID string `json:"keyId"`
+
+	// PrivateKeyFile is the path to a PEM encoded ES256 private key (.p8).
+	PrivateKeyFile string `json:"privateKeyFile"`
+
+	// Audience is the aud claim, e.g. https://appleid.apple.com
+	Audience string `json:"audience"`
+
+	// LifetimeSeconds is how long each minted JWT is valid for.
+	// Defaults to a day.
+	LifetimeSeconds int64 `json:"lifetimeSeconds,omitempty"`
+}
+
+type providersFile struct {
+	Providers []ProviderConfig `json:"providers"`
+}
+
+var providerIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// reservedAuthParams are the authorization request parameters the
+// application sets itself. Letting authParams set them could switch off PKCE
+// or the nonce, or send the response somewhere else. request and request_uri
+// are included because a request object replaces the other parameters.
+var reservedAuthParams = []string{
+	"response_type",
+	"client_id",
+	"redirect_uri",
+	"scope",
+	"state",
+	"nonce",
+	"code_challenge",
+	"code_challenge_method",
+	"response_mode",
+	"max_age",
+	"request",
+	"request_uri",
+}
+
+func loadProviders(path string) ([]ProviderConfig, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading providers file: %w", err)
+	}
+
+	var file providersFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("error parsing providers file %s: %w", path, err)
+	}
+
+	seen := map[string]bool{}
+	for i := range file.Providers {
+		p := &file.Providers[i]
+		p.expandEnv()
+
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		if seen[p.ID] {
+			return nil, fmt.Errorf("provider %s is configured more than once", p.ID)
+		}
+		seen[p.ID] = true
+	}
+
+	return file.Providers, nil
+}
+
+func (p *ProviderConfig) expandEnv() {
+	p.DisplayName = os.ExpandEnv(p.DisplayName)
+	p.DiscoveryURL = os.ExpandEnv(p.DiscoveryURL)
+	p.ClientID = os.ExpandEnv(p.ClientID)
+	p.ClientSecret = os.ExpandEnv(p.ClientSecret)
+	for k, v := range p.AuthParams {
+		p.AuthParams[k] = os.ExpandEnv(v)
+	}
+	for i, tenantID := range p.AllowedTenantIDs {
+		p.AllowedTenantIDs[i] = os.ExpandEnv(tenantID)
+	}
+	if p.OAuth2 != nil {
+		p.OAuth2.AuthorizationURL = os.ExpandEnv(p.OAuth2.AuthorizationURL)
+		p.OAuth2.TokenURL = os.ExpandEnv(p.OAuth2.TokenURL)
+		p.OAuth2.ProfileURL = os.ExpandEnv(p.OAuth2.ProfileURL)
+		p.OAuth2.EmailsURL = os.ExpandEnv(p.OAuth2.EmailsURL)
+		p.OAuth2.RevocationURL = os.ExpandEnv(p.OAuth2.RevocationURL)
+	}
+	if p.ClientSecretJWT != nil {
+		p.ClientSecretJWT.Issuer = os.ExpandEnv(p.ClientSecretJWT.Issuer)
+		p.ClientSecretJWT.KeyID = os.ExpandEnv(p.ClientSecretJWT.KeyID)
+		p.ClientSecretJWT.PrivateKeyFile = os.ExpandEnv(p.ClientSecretJWT.PrivateKeyFile)
+		p.ClientSecretJWT.Audience = os.ExpandEnv(p.ClientSecretJWT.Audience)
+	}
+}
+
+func (p *ProviderConfig) validate() error {
+	if !providerIDPattern.MatchString(p.ID) {
+		return fmt.Errorf("invalid provider id: %q", p.ID)
+	}
+	if p.DisplayName == "" {
+		p.DisplayName = p.ID
+	}
+
+	switch p.Kind {
+	case "", ProviderKindOIDC:
+		p.Kind = ProviderKindOIDC
+		if p.DiscoveryURL == "" {
+			return fmt.Errorf("provider %s: discoveryUrl is required", p.ID)
+		}
+
+	case ProviderKindOAuth2:
+		if err := p.OAuth2.validate(p.ID); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("provider %s: unknown kind %q", p.ID, p.Kind)
+	}
+
+	if p.ClientID == "" {
+		return fmt.Errorf("provider %s: clientId is required", p.ID)
+	}
+	if len(p.Scopes) == 0 && p.Kind == ProviderKindOIDC {
+		p.Scopes = []string{"openid", "email"}
+	}
+	for key := range p.AuthParams {
+		if slices.Contains(reservedAuthParams, key) {
+			return fmt.Errorf("provider %s: authParams can't set %s", p.ID, key)
+		}
+	}
+	if p.ResponseMode != "" && p.ResponseMode != "query" && p.ResponseMode != "form_post" {
+		return fmt.Errorf("provider %s: unsupported responseMode %q", p.ID, p.ResponseMode)
+	}
+	if p.FrontChannelLogout != nil && p.Kind != ProviderKindOIDC {
+		return fmt.Errorf("provider %s: frontChannelLogout is only supported for OIDC providers", p.ID)
+	}
+	if jwtCfg := p.ClientSecretJWT; jwtCfg != nil {
+		if p.ClientSecret != "" {
+			return fmt.Errorf("provider %s: clientSecret and clientSecretJwt are mutually exclusive", p.ID)
+		}
+		if jwtCfg.Issuer == "" || jwtCfg.KeyID == "" || jwtCfg.PrivateKeyFile == "" || jwtCfg.Audience == "" {
+			return fmt.Errorf("provider %s: clientSecretJwt needs issuer, keyId, privateKeyFile and audience", p.ID)
+		}
+		if jwtCfg.LifetimeSeconds <= 0 {
+			jwtCfg.LifetimeSeconds = 24 * 60 * 60
+		}
+	}
+	return nil
+}
+
+func (c *OAuth2ProviderConfig) validate(providerID string) error {
+	if c == nil {
+		return fmt.Errorf("provider %s: oauth2 settings are required", providerID)
+	}
+	if c.AuthorizationURL == "" || c.TokenURL == "" || c.ProfileURL == "" {
+		return fmt.Errorf("provider %s: oauth2 needs authorizationUrl, tokenUrl and profileUrl", providerID)
+	}
+	if c.SubjectField == "" {
+		c.SubjectField = "id"
+	}
+	if c.EmailField == "" {
+		c.EmailField = "email"
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package config
+
+import "testing"
+
+func TestValidateRejectsReservedAuthParams(t *testing.T) {
+	for _, key := range reservedAuthParams {
+		t.Run(key, func(t *testing.T) {
+			p := ProviderConfig{
+				ID:           "example",
+				DiscoveryURL: "https://accounts.example.com/.well-known/openid-configuration",
+				ClientID:     "client",
+				AuthParams:   map[string]string{key: "value"},
+			}
+			if err := p.validate(); err == nil {
+				t.Errorf("authParams accepted %s", key)
+			}
+		})
+	}
+
+	p := ProviderConfig{
+		ID:           "example",
+		DiscoveryURL: "https://accounts.example.com/.well-known/openid-configuration",
+		ClientID:     "client",
+		AuthParams:   map[string]string{"prompt": "select_account", "hd": "example.com"},
+	}
+	if err := p.validate(); err != nil {
+		t.Errorf("validate() rejected ordinary authParams: %v", err)
+	}
+}
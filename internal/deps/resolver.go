@@ -4,14 +4,20 @@ import (
 	"context"
 
 	"github.com/Nick-Anderssohn/oidc-demo/internal/config"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/encryption"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/provider"
 	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Resolver struct {
-	DBPool  *pgxpool.Pool
-	Queries *dal.Queries
-	Config  *config.Config
+	DBPool    *pgxpool.Pool
+	Queries   *dal.Queries
+	Config    *config.Config
+	Providers *provider.Registry
+
+	// Keyring encrypts provider tokens and ID token claims at rest.
+	Keyring *encryption.Keyring
 }
 
 func InitDepsResolver(ctx context.Context) (Resolver, error) {
@@ -27,10 +33,25 @@ func InitDepsResolver(ctx context.Context) (Resolver, error) {
 
 	queries := dal.New(dbPool)
 
+	providers, err := provider.NewRegistry(&cfg)
+	if err != nil {
+		dbPool.Close()
+		return Resolver{}, err
+	}
+
+	keyring, err := encryption.NewKeyring(cfg.EncryptionConfig.KEKs)
+	if err != nil {
+		dbPool.Close()
+		return Resolver{}, err
+	}
+
 	return Resolver{
-		DBPool:  dbPool,
-		Queries: queries,
-		Config:  &cfg,
+		DBPool:    dbPool,
+		Queries:   queries,
+		Config:    &cfg,
+		Providers: providers,
+
+		Keyring: keyring,
 	}, nil
 }
 
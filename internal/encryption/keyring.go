@@ -0,0 +1,176 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// version2 is the ciphertext format: envelope encryption, where every value
+// gets its own random data key, which is wrapped by a key-encryption key
+// (KEK):
+// version || KEK version || wrapped data key || nonce || sealed data.
+// The first byte of every ciphertext is the format, so that a future format
+// can be told apart. Anything else fails to decrypt.
+const version2 byte = 2
+
+const (
+	keySize = 32
+
+	// headerSize is the version byte plus the big-endian KEK version.
+	headerSize = 1 + 4
+
+	// wrappedKeySize is the nonce plus the sealed data key.
+	wrappedKeySize = 12 + keySize + 16
+)
+
+var ErrDecrypt = errors.New("failed to decrypt")
+
+// Keyring encrypts small secrets, like the tokens an identity provider
+// issues, using envelope encryption with AES-256-GCM. It holds every KEK
+// version that may still be in use. New values are always encrypted with
+// the highest version, so rotating a key means adding a new version and
+// running the reencrypt command before removing the old one.
+type Keyring struct {
+	keks           map[uint32]cipher.AEAD
+	currentVersion uint32
+}
+
+// NewKeyring creates a Keyring from 32 byte KEKs, keyed by version.
+func NewKeyring(keks map[uint32][]byte) (*Keyring, error) {
+	if len(keks) == 0 {
+		return nil, fmt.Errorf("at least one key-encryption key is required")
+	}
+
+	keyring := &Keyring{keks: map[uint32]cipher.AEAD{}}
+	for version, key := range keks {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("key-encryption key %d: %w", version, err)
+		}
+		keyring.keks[version] = aead
+		keyring.currentVersion = max(keyring.currentVersion, version)
+	}
+
+	return keyring, nil
+}
+
+// CurrentVersion is the KEK version new values are encrypted with.
+func (k *Keyring) CurrentVersion() uint32 {
+	return k.currentVersion
+}
+
+// Encrypt encrypts plaintext. The associated data isn't stored, but the same
+// value has to be passed to Decrypt. Passing the ID of the row the
+// ciphertext is stored in prevents it from being copied to another row.
+func (k *Keyring) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	header := make([]byte, headerSize)
+	header[0] = version2
+	binary.BigEndian.PutUint32(header[1:], k.currentVersion)
+
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	// The header is authenticated when wrapping the data key so that the KEK
+	// version can't be tampered with.
+	wrappedKey, err := seal(k.keks[k.currentVersion], dataKey, header)
+	if err != nil {
+		return nil, err
+	}
+
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := seal(dataAEAD, plaintext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(header)+len(wrappedKey)+len(sealed))
+	out = append(out, header...)
+	out = append(out, wrappedKey...)
+	return append(out, sealed...), nil
+}
+
+// Decrypt reverses Encrypt.
+func (k *Keyring) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	if len(ciphertext) < headerSize+wrappedKeySize || ciphertext[0] != version2 {
+		return nil, ErrDecrypt
+	}
+
+	header := ciphertext[:headerSize]
+	kek, ok := k.keks[binary.BigEndian.Uint32(header[1:])]
+	if !ok {
+		return nil, ErrDecrypt
+	}
+
+	dataKey, err := open(kek, ciphertext[headerSize:headerSize+wrappedKeySize], header)
+	if err != nil {
+		return nil, err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return open(dataAEAD, ciphertext[headerSize+wrappedKeySize:], associatedData)
+}
+
+// NeedsReencrypt reports whether ciphertext was encrypted with an old KEK
+// version.
+func (k *Keyring) NeedsReencrypt(ciphertext []byte) bool {
+	if len(ciphertext) < headerSize || ciphertext[0] != version2 {
+		return true
+	}
+	return binary.BigEndian.Uint32(ciphertext[1:headerSize]) != k.currentVersion
+}
+
+// Reencrypt decrypts ciphertext and encrypts it again with the current KEK.
+func (k *Keyring) Reencrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	plaintext, err := k.Decrypt(ciphertext, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	return k.Encrypt(plaintext, associatedData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(key))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal returns nonce || sealed plaintext.
+func seal(aead cipher.AEAD, plaintext, associatedData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, associatedData), nil
+}
+
+// open reverses seal.
+func open(aead cipher.AEAD, ciphertext, associatedData []byte) ([]byte, error) {
+	nonceSize := aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, ErrDecrypt
+	}
+
+	plaintext, err := aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], associatedData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,203 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestKeyring(t *testing.T, keks map[uint32][]byte) *Keyring {
+	t.Helper()
+
+	keyring, err := NewKeyring(keks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	keyring := newTestKeyring(t, map[uint32][]byte{1: newKey(t), 2: newKey(t)})
+	if keyring.CurrentVersion() != 2 {
+		t.Fatalf("CurrentVersion() = %d, want 2", keyring.CurrentVersion())
+	}
+
+	tests := []struct {
+		name           string
+		plaintext      []byte
+		associatedData []byte
+	}{
+		{"empty", []byte{}, []byte("row-1")},
+		{"token", []byte("eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiIxMjMifQ.c2ln"), []byte("row-1")},
+		{"no associated data", []byte("secret"), nil},
+		{"large", bytes.Repeat([]byte("x"), 64*1024), []byte("row-2")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ciphertext, err := keyring.Encrypt(tt.plaintext, tt.associatedData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keyring.NeedsReencrypt(ciphertext) {
+				t.Error("fresh ciphertext needs reencrypting")
+			}
+
+			plaintext, err := keyring.Decrypt(ciphertext, tt.associatedData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(plaintext, tt.plaintext) {
+				t.Errorf("Decrypt() = %q, want %q", plaintext, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestKeyringEncryptIsRandomized(t *testing.T) {
+	keyring := newTestKeyring(t, map[uint32][]byte{1: newKey(t)})
+
+	first, err := keyring.Encrypt([]byte("secret"), []byte("row-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := keyring.Encrypt([]byte("secret"), []byte("row-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("encrypting the same value twice gave the same ciphertext")
+	}
+}
+
+func TestKeyringRejectsTampering(t *testing.T) {
+	key1, key2 := newKey(t), newKey(t)
+	keyring := newTestKeyring(t, map[uint32][]byte{1: key1, 2: key2})
+
+	ciphertext, err := keyring.Encrypt([]byte("secret"), []byte("row-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every byte is covered by one of the two GCM tags, including the
+	// version header.
+	for i := range ciphertext {
+		tampered := bytes.Clone(ciphertext)
+		tampered[i] ^= 0x01
+		if _, err := keyring.Decrypt(tampered, []byte("row-1")); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("flipping byte %d: error = %v, want ErrDecrypt", i, err)
+		}
+	}
+
+	// A ciphertext copied to another row doesn't decrypt there.
+	if _, err := keyring.Decrypt(ciphertext, []byte("row-2")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("wrong associated data: error = %v, want ErrDecrypt", err)
+	}
+
+	// Pointing the header at another KEK the keyring has must fail too.
+	relabeled := bytes.Clone(ciphertext)
+	relabeled[headerSize-1] = 1
+	if _, err := keyring.Decrypt(relabeled, []byte("row-1")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("relabeled KEK version: error = %v, want ErrDecrypt", err)
+	}
+
+	invalid := map[string][]byte{
+		"empty":             nil,
+		"unknown format":    append([]byte{3}, ciphertext[1:]...),
+		"truncated":         ciphertext[:len(ciphertext)-1],
+		"header only":       ciphertext[:headerSize],
+		"no sealed data":    ciphertext[:headerSize+wrappedKeySize],
+		"appended byte":     append(bytes.Clone(ciphertext), 0),
+		"version byte only": {version2},
+	}
+	for name, value := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := keyring.Decrypt(value, []byte("row-1")); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("error = %v, want ErrDecrypt", err)
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	key1, key2 := newKey(t), newKey(t)
+	old := newTestKeyring(t, map[uint32][]byte{1: key1})
+	rotated := newTestKeyring(t, map[uint32][]byte{1: key1, 2: key2})
+	withoutOld := newTestKeyring(t, map[uint32][]byte{2: key2})
+
+	ciphertext, err := old.Encrypt([]byte("secret"), []byte("row-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !rotated.NeedsReencrypt(ciphertext) {
+		t.Error("ciphertext under the old KEK does not need reencrypting")
+	}
+	if _, err := withoutOld.Decrypt(ciphertext, []byte("row-1")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("decrypting without the old KEK: error = %v, want ErrDecrypt", err)
+	}
+
+	reencrypted, err := rotated.Reencrypt(ciphertext, []byte("row-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.NeedsReencrypt(reencrypted) {
+		t.Error("reencrypted ciphertext still needs reencrypting")
+	}
+
+	// Once everything is reencrypted, the old KEK can be removed.
+	plaintext, err := withoutOld.Decrypt(reencrypted, []byte("row-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("Decrypt() = %q, want %q", plaintext, "secret")
+	}
+
+	if _, err := rotated.Reencrypt(ciphertext, []byte("row-2")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("reencrypting with the wrong associated data: error = %v, want ErrDecrypt", err)
+	}
+}
+
+func TestKeyringRejectsDataSealedWithTheKEK(t *testing.T) {
+	key1 := newKey(t)
+	keyring := newTestKeyring(t, map[uint32][]byte{1: key1})
+
+	// Data sealed directly with a KEK, without a wrapped data key, isn't a
+	// format the keyring writes, whatever the version byte says.
+	aead, err := newAEAD(key1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := seal(aead, []byte("secret"), []byte("row-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, version := range []byte{0, 1, version2, 3} {
+		ciphertext := append([]byte{version}, sealed...)
+		if _, err := keyring.Decrypt(ciphertext, []byte("row-1")); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("version %d: error = %v, want ErrDecrypt", version, err)
+		}
+	}
+}
+
+func TestNewKeyringValidatesKeys(t *testing.T) {
+	if _, err := NewKeyring(nil); err == nil {
+		t.Error("NewKeyring(nil) succeeded")
+	}
+	if _, err := NewKeyring(map[uint32][]byte{1: make([]byte, 16)}); err == nil {
+		t.Error("NewKeyring accepted a 16 byte key")
+	}
+}
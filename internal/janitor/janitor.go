@@ -0,0 +1,135 @@
+package janitor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Janitor periodically deletes rows that are no longer useful: expired state
+// tokens, nonces and logout token IDs old enough that no token carrying them
+// could still be accepted, and expired sessions. Rows are deleted in batches so that a large
+// backlog doesn't turn into one long-running, lock-heavy statement.
+type Janitor struct {
+	Resolver *deps.Resolver
+
+	Interval           time.Duration
+	BatchSize          int32
+	NonceRetention     time.Duration
+	SessionLifetime    time.Duration
+	SessionIdleTimeout time.Duration
+}
+
+// SweepResult is the number of rows removed from each table by a sweep.
+type SweepResult struct {
+	StateTokens  int64
+	Nonces       int64
+	LogoutTokens int64
+	Sessions     int64
+}
+
+// Run sweeps once immediately and then every Interval, until ctx is done.
+func (j *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := j.Sweep(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("janitor sweep failed: %v", err)
+		}
+		if result.StateTokens+result.Nonces+result.LogoutTokens+result.Sessions > 0 {
+			log.Printf(
+				"janitor removed %d state tokens, %d nonces, %d logout tokens and %d sessions",
+				result.StateTokens,
+				result.Nonces,
+				result.LogoutTokens,
+				result.Sessions,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("janitor stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep deletes every expired row, one batch at a time. The counts in the
+// result are accurate even if an error is returned partway through.
+func (j *Janitor) Sweep(ctx context.Context) (SweepResult, error) {
+	queries := j.Resolver.Queries
+	var result SweepResult
+	var err error
+
+	result.StateTokens, err = deleteInBatches(ctx, j.BatchSize, func(ctx context.Context) (int64, error) {
+		return queries.DeleteExpiredStateTokens(ctx, j.BatchSize)
+	})
+	if err != nil {
+		return result, err
+	}
+
+	result.Nonces, err = deleteInBatches(ctx, j.BatchSize, func(ctx context.Context) (int64, error) {
+		return queries.DeleteExpiredNonces(ctx, dal.DeleteExpiredNoncesParams{
+			MaxAge:    toInterval(j.NonceRetention),
+			BatchSize: j.BatchSize,
+		})
+	})
+	if err != nil {
+		return result, err
+	}
+
+	result.LogoutTokens, err = deleteInBatches(ctx, j.BatchSize, func(ctx context.Context) (int64, error) {
+		return queries.DeleteExpiredLogoutTokens(ctx, dal.DeleteExpiredLogoutTokensParams{
+			MaxAge:    toInterval(j.NonceRetention),
+			BatchSize: j.BatchSize,
+		})
+	})
+	if err != nil {
+		return result, err
+	}
+
+	result.Sessions, err = deleteInBatches(ctx, j.BatchSize, func(ctx context.Context) (int64, error) {
+		return queries.DeleteExpiredSessions(ctx, dal.DeleteExpiredSessionsParams{
+			MaxAge:      toInterval(j.SessionLifetime),
+			IdleTimeout: toInterval(j.SessionIdleTimeout),
+			BatchSize:   j.BatchSize,
+		})
+	})
+
+	return result, err
+}
+
+// deleteInBatches calls deleteBatch until it deletes less than a full batch.
+func deleteInBatches(
+	ctx context.Context,
+	batchSize int32,
+	deleteBatch func(ctx context.Context) (int64, error),
+) (int64, error) {
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		deleted, err := deleteBatch(ctx)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+
+		if deleted < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
+
+func toInterval(d time.Duration) pgtype.Interval {
+	return pgtype.Interval{Microseconds: d.Microseconds(), Valid: true}
+}
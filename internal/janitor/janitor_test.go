@@ -0,0 +1,69 @@
+package janitor
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDeleteInBatches(t *testing.T) {
+	errDelete := errors.New("delete failed")
+
+	tests := []struct {
+		name      string
+		batchSize int32
+		batches   []int64
+		failOn    int
+		wantTotal int64
+		wantCalls int
+		wantErr   error
+	}{
+		{"nothing to delete", 10, []int64{0}, -1, 0, 1, nil},
+		{"partial batch", 10, []int64{4}, -1, 4, 1, nil},
+		{"several batches", 10, []int64{10, 10, 3}, -1, 23, 3, nil},
+		{"exact multiple", 10, []int64{10, 10, 0}, -1, 20, 3, nil},
+		{"error partway", 10, []int64{10, 10, 10}, 1, 10, 2, errDelete},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			total, err := deleteInBatches(context.Background(), tt.batchSize, func(ctx context.Context) (int64, error) {
+				call := calls
+				calls++
+				if call == tt.failOn {
+					return 0, errDelete
+				}
+				return tt.batches[call], nil
+			})
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", total, tt.wantTotal)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("deleteBatch called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestDeleteInBatchesStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	total, err := deleteInBatches(ctx, 10, func(ctx context.Context) (int64, error) {
+		calls++
+		cancel()
+		return 10, nil
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if total != 10 || calls != 1 {
+		t.Errorf("deleted %d rows in %d calls, want 10 in 1", total, calls)
+	}
+}
@@ -0,0 +1,104 @@
+package keyrotation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// These queries aren't generated, since demo.identity.most_recent_id_token
+// only exists until the migration that drops it has run, and so isn't in the
+// schema sqlc generates from.
+const (
+	legacyClaimsColumnExists = `
+select exists (
+    select 1
+    from information_schema.columns
+    where table_schema = 'demo'
+      and table_name = 'identity'
+      and column_name = 'most_recent_id_token'
+)`
+
+	listLegacyClaims = `
+select id, most_recent_id_token
+from demo.identity
+where most_recent_id_token is not null
+order by id
+limit $1
+for update`
+
+	encryptLegacyClaims = `
+update demo.identity
+set encrypted_most_recent_id_token = $2,
+    most_recent_id_token = null
+where id = $1`
+)
+
+// EncryptLegacyClaims encrypts the ID token claims written before claims were
+// encrypted, and removes the plaintext. It does nothing once the plaintext
+// column has been dropped. The server runs it at startup, so the plaintext
+// is gone before the migration that drops the column is run.
+func (r *Reencrypter) EncryptLegacyClaims(ctx context.Context) (int64, error) {
+	var exists bool
+	if err := r.Resolver.DBPool.QueryRow(ctx, legacyClaimsColumnExists).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		var encrypted int64
+		err := pgx.BeginFunc(ctx, r.Resolver.DBPool, func(tx pgx.Tx) error {
+			rows, err := tx.Query(ctx, listLegacyClaims, r.BatchSize)
+			if err != nil {
+				return err
+			}
+
+			type legacyClaims struct {
+				id     pgtype.UUID
+				claims []byte
+			}
+			var records []legacyClaims
+			for rows.Next() {
+				var record legacyClaims
+				if err := rows.Scan(&record.id, &record.claims); err != nil {
+					rows.Close()
+					return err
+				}
+				records = append(records, record)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for _, record := range records {
+				ciphertext, err := r.Resolver.Keyring.Encrypt(record.claims, record.id.Bytes[:])
+				if err != nil {
+					return fmt.Errorf("identity %s: %v", record.id, err)
+				}
+				if _, err := tx.Exec(ctx, encryptLegacyClaims, record.id, ciphertext); err != nil {
+					return err
+				}
+				encrypted++
+			}
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += encrypted
+
+		if encrypted < int64(r.BatchSize) {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package keyrotation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Reencrypter rewrites every encrypted column with the current key-encryption
+// key, and encrypts ID token claims that are still stored as plaintext. Rows
+// are processed in batches, each in its own transaction, with the batch
+// locked so that a concurrent token refresh can't be overwritten.
+type Reencrypter struct {
+	Resolver *deps.Resolver
+
+	BatchSize int32
+}
+
+// Result is the number of rows rewritten in each table.
+type Result struct {
+	IdentityTokens   int64
+	IdentityClaims   int64
+	TokenRevocations int64
+	SessionIDTokens  int64
+}
+
+// Run re-encrypts every row that needs it. Once it has finished, KEK versions
+// other than the current one are no longer used and can be removed. The
+// counts in the result are accurate even if an error is returned partway
+// through.
+func (r *Reencrypter) Run(ctx context.Context) (Result, error) {
+	var result Result
+	var err error
+
+	result.IdentityTokens, err = inBatches(ctx, r, pgtype.UUID{Valid: true}, r.reencryptTokens)
+	if err != nil {
+		return result, fmt.Errorf("failed to re-encrypt identity tokens: %v", err)
+	}
+
+	result.IdentityClaims, err = r.EncryptLegacyClaims(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to encrypt legacy identity claims: %v", err)
+	}
+
+	reencryptedClaims, err := inBatches(ctx, r, pgtype.UUID{Valid: true}, r.reencryptClaims)
+	result.IdentityClaims += reencryptedClaims
+	if err != nil {
+		return result, fmt.Errorf("failed to re-encrypt identity claims: %v", err)
+	}
+
+	result.TokenRevocations, err = inBatches(ctx, r, pgtype.UUID{Valid: true}, r.reencryptRevocations)
+	if err != nil {
+		return result, fmt.Errorf("failed to re-encrypt token revocations: %v", err)
+	}
+
+	// Sessions are keyed by the hash of the session ID, which is text.
+	result.SessionIDTokens, err = inBatches(ctx, r, "", r.reencryptSessions)
+	if err != nil {
+		return result, fmt.Errorf("failed to re-encrypt session ID tokens: %v", err)
+	}
+
+	return result, nil
+}
+
+// batchFunc processes the rows with an ID greater than after. It returns the
+// last ID it saw, how many rows it rewrote and whether there may be more rows.
+type batchFunc[ID any] func(ctx context.Context, queries *dal.Queries, after ID) (last ID, rewritten int64, more bool, err error)
+
+// inBatches runs batch until it runs out of rows, starting after the given
+// ID, which should sort before every real ID.
+func inBatches[ID any](ctx context.Context, r *Reencrypter, after ID, batch batchFunc[ID]) (int64, error) {
+	var total int64
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		var rewritten int64
+		var more bool
+		err := pgx.BeginFunc(ctx, r.Resolver.DBPool, func(tx pgx.Tx) error {
+			var err error
+			after, rewritten, more, err = batch(ctx, r.Resolver.Queries.WithTx(tx), after)
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+		total += rewritten
+
+		if !more {
+			return total, nil
+		}
+	}
+}
+
+func (r *Reencrypter) reencryptTokens(ctx context.Context, queries *dal.Queries, after pgtype.UUID) (pgtype.UUID, int64, bool, error) {
+	keyring := r.Resolver.Keyring
+
+	records, err := queries.ListIdentityTokensForReencrypt(ctx, dal.ListIdentityTokensForReencryptParams{
+		After:     after,
+		BatchSize: r.BatchSize,
+	})
+	if err != nil {
+		return after, 0, false, err
+	}
+
+	var rewritten int64
+	for _, record := range records {
+		after = record.IdentityID
+
+		refreshNeedsReencrypt := record.EncryptedRefreshToken != nil && keyring.NeedsReencrypt(record.EncryptedRefreshToken)
+		if !keyring.NeedsReencrypt(record.EncryptedAccessToken) && !refreshNeedsReencrypt {
+			continue
+		}
+
+		associatedData := record.IdentityID.Bytes[:]
+
+		accessToken, err := keyring.Reencrypt(record.EncryptedAccessToken, associatedData)
+		if err != nil {
+			return after, rewritten, false, fmt.Errorf("identity %s: %v", record.IdentityID, err)
+		}
+
+		var refreshToken []byte
+		if record.EncryptedRefreshToken != nil {
+			refreshToken, err = keyring.Reencrypt(record.EncryptedRefreshToken, associatedData)
+			if err != nil {
+				return after, rewritten, false, fmt.Errorf("identity %s: %v", record.IdentityID, err)
+			}
+		}
+
+		if err := queries.UpdateIdentityTokenCiphertexts(ctx, dal.UpdateIdentityTokenCiphertextsParams{
+			IdentityID:            record.IdentityID,
+			EncryptedAccessToken:  accessToken,
+			EncryptedRefreshToken: refreshToken,
+		}); err != nil {
+			return after, rewritten, false, err
+		}
+		rewritten++
+	}
+
+	return after, rewritten, len(records) == int(r.BatchSize), nil
+}
+
+func (r *Reencrypter) reencryptClaims(ctx context.Context, queries *dal.Queries, after pgtype.UUID) (pgtype.UUID, int64, bool, error) {
+	keyring := r.Resolver.Keyring
+
+	records, err := queries.ListIdentityClaimsForReencrypt(ctx, dal.ListIdentityClaimsForReencryptParams{
+		After:     after,
+		BatchSize: r.BatchSize,
+	})
+	if err != nil {
+		return after, 0, false, err
+	}
+
+	var rewritten int64
+	for _, record := range records {
+		after = record.ID
+		if !keyring.NeedsReencrypt(record.EncryptedMostRecentIDToken) {
+			continue
+		}
+
+		encrypted, err := keyring.Reencrypt(record.EncryptedMostRecentIDToken, record.ID.Bytes[:])
+		if err != nil {
+			return after, rewritten, false, fmt.Errorf("identity %s: %v", record.ID, err)
+		}
+
+		if err := queries.SetIdentityClaims(ctx, dal.SetIdentityClaimsParams{
+			ID:                         record.ID,
+			EncryptedMostRecentIDToken: encrypted,
+		}); err != nil {
+			return after, rewritten, false, err
+		}
+		rewritten++
+	}
+
+	return after, rewritten, len(records) == int(r.BatchSize), nil
+}
+
+func (r *Reencrypter) reencryptRevocations(ctx context.Context, queries *dal.Queries, after pgtype.UUID) (pgtype.UUID, int64, bool, error) {
+	keyring := r.Resolver.Keyring
+
+	records, err := queries.ListTokenRevocationsForReencrypt(ctx, dal.ListTokenRevocationsForReencryptParams{
+		After:     after,
+		BatchSize: r.BatchSize,
+	})
+	if err != nil {
+		return after, 0, false, err
+	}
+
+	var rewritten int64
+	for _, record := range records {
+		after = record.ID
+		if !keyring.NeedsReencrypt(record.EncryptedToken) {
+			continue
+		}
+
+		encrypted, err := keyring.Reencrypt(record.EncryptedToken, record.ID.Bytes[:])
+		if err != nil {
+			return after, rewritten, false, fmt.Errorf("token revocation %s: %v", record.ID, err)
+		}
+
+		if err := queries.UpdateTokenRevocationCiphertext(ctx, dal.UpdateTokenRevocationCiphertextParams{
+			ID:             record.ID,
+			EncryptedToken: encrypted,
+		}); err != nil {
+			return after, rewritten, false, err
+		}
+		rewritten++
+	}
+
+	return after, rewritten, len(records) == int(r.BatchSize), nil
+}
+
+func (r *Reencrypter) reencryptSessions(ctx context.Context, queries *dal.Queries, after string) (string, int64, bool, error) {
+	keyring := r.Resolver.Keyring
+
+	records, err := queries.ListSessionIDTokensForReencrypt(ctx, dal.ListSessionIDTokensForReencryptParams{
+		After:     after,
+		BatchSize: r.BatchSize,
+	})
+	if err != nil {
+		return after, 0, false, err
+	}
+
+	var rewritten int64
+	for _, record := range records {
+		after = record.ID
+		if !keyring.NeedsReencrypt(record.EncryptedIDToken) {
+			continue
+		}
+
+		// The ID token is encrypted with the hashed session ID as associated
+		// data.
+		encrypted, err := keyring.Reencrypt(record.EncryptedIDToken, []byte(record.ID))
+		if err != nil {
+			return after, rewritten, false, fmt.Errorf("session %s: %v", record.ID, err)
+		}
+
+		// session_set_updated_at leaves updated_at alone when only the ID
+		// token changes, so this doesn't keep an idle session alive.
+		if err := queries.UpdateSessionIDTokenCiphertext(ctx, dal.UpdateSessionIDTokenCiphertextParams{
+			ID:               record.ID,
+			EncryptedIDToken: encrypted,
+		}); err != nil {
+			return after, rewritten, false, err
+		}
+		rewritten++
+	}
+
+	return after, rewritten, len(records) == int(r.BatchSize), nil
+}
@@ -0,0 +1,103 @@
+package keyrotation
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/encryption"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/testdb"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/util"
+	"github.com/jackc/pgx/v5"
+)
+
+func newKEK(t *testing.T) []byte {
+	t.Helper()
+	kek := make([]byte, 32)
+	if _, err := rand.Read(kek); err != nil {
+		t.Fatal(err)
+	}
+	return kek
+}
+
+func TestReencryptSessionsKeepsUpdatedAt(t *testing.T) {
+	pool, queries := testdb.Connect(t)
+	ctx := context.Background()
+	user := testdb.NewUser(t, queries)
+
+	oldKEK, newKEK := newKEK(t), newKEK(t)
+	oldKeyring, err := encryption.NewKeyring(map[uint32][]byte{1: oldKEK})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := encryption.NewKeyring(map[uint32][]byte{1: oldKEK, 2: newKEK})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionID, err := util.GenerateSecureID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := oldKeyring.Encrypt([]byte("id token"), []byte(sessionID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Inserted directly so that the session looks idle.
+	lastUsed := time.Now().Add(-24 * time.Hour).Truncate(time.Microsecond)
+	if _, err := pool.Exec(ctx, `
+		insert into demo.session (id, user_id, encrypted_id_token, updated_at)
+		values ($1, $2, $3, $4)`,
+		sessionID, user.ID, encrypted, lastUsed); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Reencrypter{
+		Resolver:  &deps.Resolver{DBPool: pool, Queries: queries, Keyring: keyring},
+		BatchSize: 1,
+	}
+
+	// Only this test's session is rewritten: no other ID sorts between its
+	// prefix and itself.
+	var rewritten int64
+	err = pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		var err error
+		_, rewritten, _, err = r.reencryptSessions(ctx, queries.WithTx(tx), sessionID[:len(sessionID)-1])
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewritten != 1 {
+		t.Fatalf("rewrote %d sessions, want 1", rewritten)
+	}
+
+	var updatedAt time.Time
+	var reencrypted []byte
+	if err := pool.QueryRow(ctx, "select updated_at, encrypted_id_token from demo.session where id = $1", sessionID).
+		Scan(&updatedAt, &reencrypted); err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(reencrypted, encrypted) || keyring.NeedsReencrypt(reencrypted) {
+		t.Error("the ID token wasn't re-encrypted with the current key")
+	}
+	if !updatedAt.Equal(lastUsed) {
+		t.Errorf("updated_at = %s, want %s", updatedAt, lastUsed)
+	}
+
+	// Anything else still counts as use.
+	if err := queries.TouchSession(ctx, sessionID); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.QueryRow(ctx, "select updated_at from demo.session where id = $1", sessionID).Scan(&updatedAt); err != nil {
+		t.Fatal(err)
+	}
+	if !updatedAt.After(lastUsed) {
+		t.Errorf("updated_at = %s after touching the session, want it to move", updatedAt)
+	}
+}
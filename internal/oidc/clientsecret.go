@@ -0,0 +1,147 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ClientSecretSource provides the client secret used to authenticate with a
+// provider's token endpoint.
+type ClientSecretSource interface {
+	ClientSecret() (string, error)
+}
+
+// StaticClientSecret is a client secret that never changes.
+type StaticClientSecret string
+
+func (s StaticClientSecret) ClientSecret() (string, error) {
+	return string(s), nil
+}
+
+// JWTClientSecret is a client secret that is a short-lived ES256 JWT, which
+// is what Sign in with Apple requires. A new JWT is minted whenever the
+// current one is within RefreshBefore of expiring.
+// https://developer.apple.com/documentation/accountorganizationaldatasharing/creating-a-client-secret
+type JWTClientSecret struct {
+	// Issuer is the iss claim. For Apple this is the team ID.
+	Issuer string
+
+	// Subject is the sub claim. For Apple this is the client ID.
+	Subject string
+
+	// Audience is the aud claim, e.g. https://appleid.apple.com
+	Audience string
+
+	// KeyID is the kid of the signing key, as registered with the provider.
+	KeyID string
+
+	PrivateKey *ecdsa.PrivateKey
+
+	// Lifetime is how long each JWT is valid for. Apple allows up to six
+	// months.
+	Lifetime time.Duration
+
+	// RefreshBefore is how long before expiry a new JWT is minted.
+	RefreshBefore time.Duration
+
+	mu        sync.Mutex
+	current   string
+	expiresAt time.Time
+}
+
+func (s *JWTClientSecret) ClientSecret() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.current != "" && now.Add(s.RefreshBefore).Before(s.expiresAt) {
+		return s.current, nil
+	}
+
+	expiresAt := now.Add(s.Lifetime)
+	token, err := signES256(
+		JOSEHeader{Alg: ES256, Kid: s.KeyID},
+		map[string]any{
+			Iss: s.Issuer,
+			Sub: s.Subject,
+			Aud: s.Audience,
+			Iat: now.Unix(),
+			Exp: expiresAt.Unix(),
+		},
+		s.PrivateKey,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign client secret: %w", err)
+	}
+
+	s.current = token
+	s.expiresAt = expiresAt
+	return token, nil
+}
+
+// LoadECPrivateKey reads a PEM encoded PKCS #8 EC private key, such as the
+// .p8 files Apple hands out.
+func LoadECPrivateKey(path string) (*ecdsa.PrivateKey, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key in %s: %w", path, err)
+	}
+
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key in %s is not an EC key", path)
+	}
+	if ecKey.Curve.Params().BitSize != curveBitsForAlg(ES256) {
+		return nil, fmt.Errorf("private key in %s is not a P-256 key", path)
+	}
+
+	return ecKey, nil
+}
+
+// signES256 produces a compact JWS of claims signed with key.
+func signES256(header JOSEHeader, claims map[string]any, key *ecdsa.PrivateKey) (string, error) {
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	// R || S, each left-padded to the size of the curve.
+	size := (key.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	s.FillBytes(signature[size:])
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -16,3 +16,8 @@ var HTTPClient = http.Client{
 		TLSHandshakeTimeout: 10 * time.Second, // Timeout for TLS handshakes
 	},
 }
+
+// JWKSCache is the cache used to look up the keys that ID tokens are signed
+// with. Keys are cached for an hour unless the provider says otherwise, and
+// an unknown kid triggers at most one refetch every five minutes.
+var JWKSCache = NewKeySetCache(time.Hour, 5*time.Minute)
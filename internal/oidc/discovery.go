@@ -1,14 +1,18 @@
 package oidc
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
-	"sync"
+	"net/http"
 	"time"
 )
 
 // DiscoveryData is referred to as "OpenID Provider Metadata" in the official
 // specification: https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+// It also models OAuth 2.0 Authorization Server Metadata, which shares most
+// of its fields: https://datatracker.ietf.org/doc/html/rfc8414#section-2
 type DiscoveryData struct {
 	Issuer                                     string   `json:"issuer"`
 	AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
@@ -45,80 +49,114 @@ type DiscoveryData struct {
 	RequireRequestURIRegistration              bool     `json:"require_request_uri_registration,omitempty"`
 	OPPolicyURI                                string   `json:"op_policy_uri,omitempty"`
 	OPTosURI                                   string   `json:"op_tos_uri,omitempty"`
-}
 
-type cachedDiscoveryData struct {
-	validUntil *time.Time
-	data       *DiscoveryData
+	// From OAuth 2.0 Authorization Server Metadata.
+	// https://datatracker.ietf.org/doc/html/rfc8414#section-2
+	RevocationEndpoint                                 string   `json:"revocation_endpoint,omitempty"`
+	RevocationEndpointAuthMethodsSupported             []string `json:"revocation_endpoint_auth_methods_supported,omitempty"`
+	RevocationEndpointAuthSigningAlgValuesSupported    []string `json:"revocation_endpoint_auth_signing_alg_values_supported,omitempty"`
+	IntrospectionEndpoint                              string   `json:"introspection_endpoint,omitempty"`
+	IntrospectionEndpointAuthMethodsSupported          []string `json:"introspection_endpoint_auth_methods_supported,omitempty"`
+	IntrospectionEndpointAuthSigningAlgValuesSupported []string `json:"introspection_endpoint_auth_signing_alg_values_supported,omitempty"`
+	CodeChallengeMethodsSupported                      []string `json:"code_challenge_methods_supported,omitempty"`
+	SignedMetadata                                     string   `json:"signed_metadata,omitempty"`
+
+	// From OAuth 2.0 Pushed Authorization Requests.
+	// https://datatracker.ietf.org/doc/html/rfc9126#section-5
+	PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint,omitempty"`
+	RequirePushedAuthorizationRequests bool   `json:"require_pushed_authorization_requests,omitempty"`
+
+	// From OAuth 2.0 Device Authorization Grant.
+	// https://datatracker.ietf.org/doc/html/rfc8628#section-4
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint,omitempty"`
+
+	// From OAuth 2.0 Authorization Server Issuer Identification.
+	// https://datatracker.ietf.org/doc/html/rfc9207#section-3
+	AuthorizationResponseIssParameterSupported bool `json:"authorization_response_iss_parameter_supported,omitempty"`
+
+	// From OAuth 2.0 Mutual-TLS Client Authentication.
+	// https://datatracker.ietf.org/doc/html/rfc8705#section-3.3
+	TLSClientCertificateBoundAccessTokens bool              `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+	MTLSEndpointAliases                   map[string]string `json:"mtls_endpoint_aliases,omitempty"`
+
+	// From OAuth 2.0 Demonstrating Proof of Possession (DPoP).
+	// https://datatracker.ietf.org/doc/html/rfc9449#section-5.1
+	DPoPSigningAlgValuesSupported []string `json:"dpop_signing_alg_values_supported,omitempty"`
+
+	// From OpenID Connect RP-Initiated Logout.
+	// https://openid.net/specs/openid-connect-rpinitiated-1_0.html#OPMetadata
+	EndSessionEndpoint string `json:"end_session_endpoint,omitempty"`
+
+	// From OpenID Connect Back-Channel Logout.
+	// https://openid.net/specs/openid-connect-backchannel-1_0.html#BCSupport
+	BackChannelLogoutSupported        bool `json:"backchannel_logout_supported,omitempty"`
+	BackChannelLogoutSessionSupported bool `json:"backchannel_logout_session_supported,omitempty"`
+
+	// From OpenID Connect Front-Channel Logout.
+	// https://openid.net/specs/openid-connect-frontchannel-1_0.html#OPLogout
+	FrontChannelLogoutSupported        bool `json:"frontchannel_logout_supported,omitempty"`
+	FrontChannelLogoutSessionSupported bool `json:"frontchannel_logout_session_supported,omitempty"`
+
+	// From OpenID Connect Session Management.
+	// https://openid.net/specs/openid-connect-session-1_0.html#OPMetadata
+	CheckSessionIframe string `json:"check_session_iframe,omitempty"`
 }
 
-const httpHeaderExpiresFormat = "Mon, 02 Jan 2006 15:04:05 MST"
-
-var discoveryCache = map[string]*cachedDiscoveryData{}
-
-var mu = &sync.Mutex{}
-var discoveryCacheMutexes = map[string]*sync.Mutex{}
-
-// GetDiscoveryData fetches the discovery data from the given URL and caches it.
-func GetDiscoveryData(url string) (*DiscoveryData, error) {
-	// First we'll check the cache without locking.
-	if data, ok := discoveryCache[url]; ok {
-		if data.validUntil == nil || time.Now().Before(*data.validUntil) {
-			return data.data, nil
-		}
+// fetchDiscoveryData downloads and validates the discovery document at url.
+// It also returns how long the document may be cached for, taken from
+// Cache-Control max-age or else from Expires, or -1 if the provider said
+// neither.
+func fetchDiscoveryData(ctx context.Context, url string, insecureHosts []string) (*DiscoveryData, time.Duration, error) {
+	if !isSecureURL(url, insecureHosts) {
+		return nil, 0, &InvalidDiscoveryError{URL: url, Field: "discovery URL", Err: ErrDiscoveryInsecureURL}
 	}
 
-	// Wasn't in the cache, safely get a mutex for this url
-	mu.Lock()
-	urlSpecificMu := discoveryCacheMutexes[url]
-	if urlSpecificMu == nil {
-		urlSpecificMu = &sync.Mutex{}
-		discoveryCacheMutexes[url] = urlSpecificMu
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, 0, err
 	}
-	mu.Unlock()
-
-	urlSpecificMu.Lock()
-	defer urlSpecificMu.Unlock()
+	req.Header.Set("Accept", "application/json")
 
-	// Check again after acquiring the lock
-	if data, ok := discoveryCache[url]; ok {
-		if data.validUntil == nil || time.Now().Before(*data.validUntil) {
-			return data.data, nil
-		}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
 	}
+	defer resp.Body.Close()
 
-	// Couldn't find it in the cache, so we need to fetch it
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, &DiscoveryStatusError{URL: url, StatusCode: resp.StatusCode}
+	}
 
-	discoveryDataResp, err := HTTPClient.Get(url)
-	if err != nil {
-		return nil, err
+	var discoveryData DiscoveryData
+	if err := json.NewDecoder(resp.Body).Decode(&discoveryData); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode discovery document: %w", err)
 	}
-	defer discoveryDataResp.Body.Close()
 
-	expiresHeader := discoveryDataResp.Header.Get("Expires")
+	if err := discoveryData.Validate(url, insecureHosts); err != nil {
+		return nil, 0, err
+	}
 
-	var validUntil time.Time
-	var nilifiedValidUntil *time.Time
+	return &discoveryData, discoveryLifetime(resp.Header), nil
+}
 
-	if expiresHeader != "" {
-		validUntil, err = time.Parse(httpHeaderExpiresFormat, expiresHeader)
-		if err != nil {
-			log.Println("could not parse Expires header:", err)
-		} else {
-			nilifiedValidUntil = &validUntil
-		}
+// discoveryLifetime prefers max-age over Expires, as HTTP caches do.
+// https://www.rfc-editor.org/rfc/rfc9111#section-4.2.1
+func discoveryLifetime(header http.Header) time.Duration {
+	if lifetime, ok := cacheLifetime(header); ok {
+		return lifetime
 	}
 
-	var discoveryData DiscoveryData
-	err = json.NewDecoder(discoveryDataResp.Body).Decode(&discoveryData)
-	if err != nil {
-		return nil, err
+	expiresHeader := header.Get("Expires")
+	if expiresHeader == "" {
+		return -1
 	}
 
-	discoveryCache[url] = &cachedDiscoveryData{
-		validUntil: nilifiedValidUntil,
-		data:       &discoveryData,
+	// An Expires that can't be parsed means the response is already stale.
+	expires, err := http.ParseTime(expiresHeader)
+	if err != nil {
+		log.Println("could not parse Expires header:", err)
+		return 0
 	}
 
-	return &discoveryData, nil
+	return max(time.Until(expires), 0)
 }
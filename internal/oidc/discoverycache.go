@@ -0,0 +1,201 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// backgroundRefreshTimeout bounds a refresh-ahead fetch, since there is no
+// request context to bound it.
+const backgroundRefreshTimeout = 30 * time.Second
+
+// DiscoveryCache caches discovery documents by discovery URL.
+//
+// Documents are kept for as long as the provider's Cache-Control max-age or
+// Expires header allows, clamped to [MinTTL, MaxTTL]. Once a document is
+// within RefreshAhead of expiring it is refetched in the background, while
+// callers keep getting the cached copy. If the provider can't be reached or
+// serves an invalid document, the last good document keeps being served and
+// the fetch is retried after MinTTL.
+type DiscoveryCache struct {
+	// DefaultTTL is used when the provider sends neither max-age nor Expires.
+	DefaultTTL time.Duration
+
+	// MinTTL is the minimum time between two fetches of the same document.
+	MinTTL time.Duration
+
+	// MaxTTL caps how long a document is cached, however long the provider
+	// says it may be.
+	MaxTTL time.Duration
+
+	// RefreshAhead is how long before a document expires it is refetched in
+	// the background. Zero disables refresh-ahead.
+	RefreshAhead time.Duration
+
+	// InsecureHosts may serve discovery documents and endpoints over plain
+	// http. It is meant for providers running locally during development.
+	InsecureHosts []string
+
+	mu      sync.Mutex
+	entries map[string]*discoveryEntry
+}
+
+type discoveryEntry struct {
+	// fetchMu is held while fetching the document, so that concurrent callers
+	// wait for one fetch rather than each starting their own.
+	fetchMu sync.Mutex
+
+	// mu guards the fields below. It is never held during a fetch.
+	mu          sync.Mutex
+	data        *DiscoveryData
+	validUntil  time.Time
+	lastFetched time.Time
+	refreshing  bool
+}
+
+// NewDiscoveryCache creates an empty DiscoveryCache.
+func NewDiscoveryCache(defaultTTL, minTTL, maxTTL, refreshAhead time.Duration) *DiscoveryCache {
+	return &DiscoveryCache{
+		DefaultTTL:   defaultTTL,
+		MinTTL:       minTTL,
+		MaxTTL:       maxTTL,
+		RefreshAhead: refreshAhead,
+		entries:      map[string]*discoveryEntry{},
+	}
+}
+
+// Get returns the discovery document served at url, fetching it if it isn't
+// cached or has expired.
+func (c *DiscoveryCache) Get(ctx context.Context, url string) (*DiscoveryData, error) {
+	entry := c.entry(url)
+
+	if data, ok := c.cached(url, entry); ok {
+		return data, nil
+	}
+
+	entry.fetchMu.Lock()
+	defer entry.fetchMu.Unlock()
+
+	// Another caller may have fetched it while we waited.
+	if data, ok := c.cached(url, entry); ok {
+		return data, nil
+	}
+
+	return c.refresh(ctx, url, entry)
+}
+
+// Resolve returns the metadata of issuer. The OpenID Connect discovery
+// document is tried first, and if the issuer doesn't serve one, its OAuth 2.0
+// Authorization Server Metadata. The issuer must come from configuration,
+// never from a request, since every URL fetched stays in the cache.
+func (c *DiscoveryCache) Resolve(ctx context.Context, issuer string) (*DiscoveryData, error) {
+	data, err := c.Get(ctx, OpenIDConfigurationURL(issuer))
+
+	var statusErr *DiscoveryStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		return data, err
+	}
+
+	metadataURL, err := AuthorizationServerMetadataURL(issuer)
+	if err != nil {
+		return nil, err
+	}
+	return c.Get(ctx, metadataURL)
+}
+
+// cached returns the entry's document if it is still valid, starting a
+// background refresh if it is about to expire.
+func (c *DiscoveryCache) cached(url string, entry *discoveryEntry) (*DiscoveryData, bool) {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	now := time.Now()
+	if entry.data == nil || !now.Before(entry.validUntil) {
+		return nil, false
+	}
+
+	// Documents that live for less than RefreshAhead would otherwise be
+	// refetched back to back, so MinTTL applies here too.
+	dueForRefresh := now.After(entry.validUntil.Add(-c.RefreshAhead)) && now.Sub(entry.lastFetched) >= c.MinTTL
+	if c.RefreshAhead > 0 && !entry.refreshing && dueForRefresh {
+		entry.refreshing = true
+		go c.refreshInBackground(url, entry)
+	}
+
+	return entry.data, true
+}
+
+func (c *DiscoveryCache) refreshInBackground(url string, entry *discoveryEntry) {
+	defer func() {
+		entry.mu.Lock()
+		entry.refreshing = false
+		entry.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), backgroundRefreshTimeout)
+	defer cancel()
+
+	entry.fetchMu.Lock()
+	defer entry.fetchMu.Unlock()
+
+	if _, err := c.refresh(ctx, url, entry); err != nil {
+		log.Printf("could not refresh discovery document from %s: %v", url, err)
+	}
+}
+
+// refresh fetches the document for entry. Must be called with entry.fetchMu
+// held. If the fetch fails and a previous document is available, the
+// previous document is returned and no error is returned.
+func (c *DiscoveryCache) refresh(ctx context.Context, url string, entry *discoveryEntry) (*DiscoveryData, error) {
+	data, lifetime, err := fetchDiscoveryData(ctx, url, c.InsecureHosts)
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	now := time.Now()
+	entry.lastFetched = now
+
+	if err != nil {
+		if entry.data == nil {
+			return nil, err
+		}
+
+		log.Printf("could not refresh discovery document from %s, serving last known document: %v", url, err)
+		entry.validUntil = now.Add(c.MinTTL)
+		return entry.data, nil
+	}
+
+	if lifetime < 0 {
+		lifetime = c.DefaultTTL
+	}
+	if c.MaxTTL > 0 && lifetime > c.MaxTTL {
+		lifetime = c.MaxTTL
+	}
+	if lifetime < c.MinTTL {
+		lifetime = c.MinTTL
+	}
+
+	entry.data = data
+	entry.validUntil = now.Add(lifetime)
+	return data, nil
+}
+
+func (c *DiscoveryCache) entry(url string) *discoveryEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[string]*discoveryEntry{}
+	}
+
+	entry, ok := c.entries[url]
+	if !ok {
+		entry = &discoveryEntry{}
+		c.entries[url] = entry
+	}
+	return entry
+}
@@ -0,0 +1,196 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// discoveryServer serves a valid discovery document over TLS and counts the
+// requests it gets. status and cacheControl can be changed while it runs.
+type discoveryServer struct {
+	*httptest.Server
+	hits         atomic.Int32
+	status       atomic.Int32
+	cacheControl atomic.Value
+}
+
+func newDiscoveryServer(t *testing.T) *discoveryServer {
+	t.Helper()
+
+	ds := &discoveryServer{}
+	ds.status.Store(http.StatusOK)
+	ds.cacheControl.Store("")
+
+	ds.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ds.hits.Add(1)
+
+		if status := int(ds.status.Load()); status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		if cacheControl := ds.cacheControl.Load().(string); cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DiscoveryData{
+			Issuer:                           ds.URL,
+			AuthorizationEndpoint:            ds.URL + "/authorize",
+			TokenEndpoint:                    ds.URL + "/token",
+			JwksURI:                          ds.URL + "/jwks",
+			ResponseTypesSupported:           []string{"code"},
+			SubjectTypesSupported:            []string{"public"},
+			IDTokenSigningAlgValuesSupported: []string{RS256},
+		})
+	}))
+	t.Cleanup(ds.Close)
+
+	// The package client has to trust the test server's certificate.
+	previousClient := HTTPClient
+	HTTPClient = *ds.Client()
+	t.Cleanup(func() { HTTPClient = previousClient })
+
+	return ds
+}
+
+func (ds *discoveryServer) discoveryURL() string {
+	return ds.URL + wellKnownOpenIDConfiguration
+}
+
+func TestDiscoveryCacheConcurrentGetAndRefreshAhead(t *testing.T) {
+	ds := newDiscoveryServer(t)
+	ds.cacheControl.Store("max-age=1")
+
+	// Every document is within RefreshAhead of expiring as soon as MinTTL
+	// has passed, so refresh-ahead runs while the callers keep reading.
+	cache := NewDiscoveryCache(time.Hour, 50*time.Millisecond, time.Hour, 900*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 40 {
+				data, err := cache.Get(context.Background(), ds.discoveryURL())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if data.Issuer != ds.URL {
+					t.Errorf("issuer = %q, want %q", data.Issuer, ds.URL)
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 400ms of reads with a refresh at most every 50ms.
+	hits := ds.hits.Load()
+	if hits < 2 {
+		t.Errorf("server got %d requests, want refresh-ahead to have refetched the document", hits)
+	}
+	if hits > 12 {
+		t.Errorf("server got %d requests, want concurrent callers to share fetches", hits)
+	}
+}
+
+func TestDiscoveryCacheServesStaleOnError(t *testing.T) {
+	ds := newDiscoveryServer(t)
+	ds.cacheControl.Store("max-age=0")
+
+	cache := NewDiscoveryCache(time.Hour, 10*time.Millisecond, time.Hour, 0)
+
+	first, err := cache.Get(context.Background(), ds.discoveryURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds.status.Store(http.StatusInternalServerError)
+	time.Sleep(20 * time.Millisecond)
+
+	stale, err := cache.Get(context.Background(), ds.discoveryURL())
+	if err != nil {
+		t.Fatalf("expected the stale document, got error %v", err)
+	}
+	if stale != first {
+		t.Error("expected the previously fetched document")
+	}
+	if hits := ds.hits.Load(); hits != 2 {
+		t.Errorf("server got %d requests, want 2", hits)
+	}
+}
+
+func TestDiscoveryCacheFirstFetchError(t *testing.T) {
+	ds := newDiscoveryServer(t)
+	ds.status.Store(http.StatusNotFound)
+
+	cache := NewDiscoveryCache(time.Hour, time.Minute, time.Hour, 0)
+
+	_, err := cache.Get(context.Background(), ds.discoveryURL())
+
+	var statusErr *DiscoveryStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("error = %v, want a DiscoveryStatusError with status 404", err)
+	}
+}
+
+func TestDiscoveryCacheMaxTTL(t *testing.T) {
+	ds := newDiscoveryServer(t)
+	ds.cacheControl.Store("max-age=86400")
+
+	cache := NewDiscoveryCache(time.Hour, 0, 20*time.Millisecond, 0)
+
+	for range 2 {
+		if _, err := cache.Get(context.Background(), ds.discoveryURL()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits := ds.hits.Load(); hits != 1 {
+		t.Fatalf("server got %d requests, want 1 while cached", hits)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, err := cache.Get(context.Background(), ds.discoveryURL()); err != nil {
+		t.Fatal(err)
+	}
+	if hits := ds.hits.Load(); hits != 2 {
+		t.Errorf("server got %d requests, want MaxTTL to have expired the document", hits)
+	}
+}
+
+func TestDiscoveryLifetime(t *testing.T) {
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		atLeast time.Duration
+		atMost  time.Duration
+	}{
+		{"nothing", http.Header{}, -1, -1},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=300"}}, 300 * time.Second, 300 * time.Second},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, 0, 0},
+		{"expires", http.Header{"Expires": {future}}, 59 * time.Minute, time.Hour},
+		{"max-age wins over expires", http.Header{"Cache-Control": {"max-age=60"}, "Expires": {future}}, time.Minute, time.Minute},
+		{"expires in the past", http.Header{"Expires": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, 0, 0},
+		{"invalid expires", http.Header{"Expires": {"0"}}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := discoveryLifetime(tt.header)
+			if got < tt.atLeast || got > tt.atMost {
+				t.Errorf("discoveryLifetime() = %s, want between %s and %s", got, tt.atLeast, tt.atMost)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package oidc
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrDiscoveryMissingField is returned when a field the specification
+	// requires is missing from a discovery document.
+	ErrDiscoveryMissingField = errors.New("required field is missing")
+
+	// ErrDiscoveryIssuerMismatch is returned when the issuer of a discovery
+	// document is not the URL it was fetched from.
+	ErrDiscoveryIssuerMismatch = errors.New("issuer does not match the discovery URL")
+
+	// ErrDiscoveryInsecureURL is returned for URLs that don't use https and
+	// whose host isn't allowed to be insecure.
+	ErrDiscoveryInsecureURL = errors.New("url is not https")
+
+	// ErrDiscoveryCodeFlowNotSupported is returned when the provider doesn't
+	// support the authorization code flow.
+	ErrDiscoveryCodeFlowNotSupported = errors.New("response type code is not supported")
+)
+
+// DiscoveryStatusError is returned when the discovery document can't be
+// fetched because the provider responded with something other than 200 OK.
+type DiscoveryStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *DiscoveryStatusError) Error() string {
+	return fmt.Sprintf("fetching discovery document from %s failed with status %d", e.URL, e.StatusCode)
+}
+
+// InvalidDiscoveryError is returned when a discovery document fails
+// validation. Err is one of the ErrDiscovery errors, and Field is the
+// metadata field that failed.
+type InvalidDiscoveryError struct {
+	URL   string
+	Field string
+	Err   error
+}
+
+func (e *InvalidDiscoveryError) Error() string {
+	return fmt.Sprintf("invalid discovery document from %s: %s: %v", e.URL, e.Field, e.Err)
+}
+
+func (e *InvalidDiscoveryError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks that the discovery document fetched from discoveryURL can
+// be trusted and used for the authorization code flow. URLs must use https
+// unless their host is in insecureHosts, which is meant for providers
+// running locally during development. Documents fetched from an OAuth 2.0
+// Authorization Server Metadata URL don't need the OpenID Connect only
+// fields here. Use ValidateOpenIDConnect when they will be used to validate
+// ID tokens.
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation
+// https://datatracker.ietf.org/doc/html/rfc8414#section-3.3
+func (d *DiscoveryData) Validate(discoveryURL string, insecureHosts []string) error {
+	invalid := func(field string, err error) error {
+		return &InvalidDiscoveryError{URL: discoveryURL, Field: field, Err: err}
+	}
+
+	issuer, isOAuthMetadata, ok := issuerFromDiscoveryURL(discoveryURL)
+	if !ok {
+		return invalid("discovery URL", ErrDiscoveryIssuerMismatch)
+	}
+
+	required := []requiredField{
+		{"issuer", d.Issuer != ""},
+		{"authorization_endpoint", d.AuthorizationEndpoint != ""},
+		{"token_endpoint", d.TokenEndpoint != ""},
+		{"response_types_supported", len(d.ResponseTypesSupported) > 0},
+	}
+	if field, ok := firstMissing(required); !ok {
+		return invalid(field, ErrDiscoveryMissingField)
+	}
+
+	if !isOAuthMetadata {
+		if err := d.ValidateOpenIDConnect(discoveryURL); err != nil {
+			return err
+		}
+	}
+
+	if !issuerMatches(strings.TrimSuffix(d.Issuer, "/"), issuer) {
+		return invalid("issuer", ErrDiscoveryIssuerMismatch)
+	}
+
+	if !slices.Contains(d.ResponseTypesSupported, "code") {
+		return invalid("response_types_supported", ErrDiscoveryCodeFlowNotSupported)
+	}
+
+	urls := []struct {
+		field string
+		value string
+	}{
+		{"issuer", d.Issuer},
+		{"authorization_endpoint", d.AuthorizationEndpoint},
+		{"token_endpoint", d.TokenEndpoint},
+		{"userinfo_endpoint", d.UserInfoEndpoint},
+		{"jwks_uri", d.JwksURI},
+		{"registration_endpoint", d.RegistrationEndpoint},
+		{"revocation_endpoint", d.RevocationEndpoint},
+		{"end_session_endpoint", d.EndSessionEndpoint},
+		{"check_session_iframe", d.CheckSessionIframe},
+		{"introspection_endpoint", d.IntrospectionEndpoint},
+		{"pushed_authorization_request_endpoint", d.PushedAuthorizationRequestEndpoint},
+		{"device_authorization_endpoint", d.DeviceAuthorizationEndpoint},
+	}
+	for _, u := range urls {
+		if u.value != "" && !isSecureURL(u.value, insecureHosts) {
+			return invalid(u.field, ErrDiscoveryInsecureURL)
+		}
+	}
+
+	return nil
+}
+
+// ValidateOpenIDConnect checks that the document has the fields needed to
+// validate ID tokens, which OAuth 2.0 Authorization Server Metadata doesn't
+// require. Without them every ID token would be rejected, so OpenID Connect
+// providers must have them whatever kind of URL the document came from.
+func (d *DiscoveryData) ValidateOpenIDConnect(discoveryURL string) error {
+	required := []requiredField{
+		{"jwks_uri", d.JwksURI != ""},
+		{"subject_types_supported", len(d.SubjectTypesSupported) > 0},
+		{"id_token_signing_alg_values_supported", len(d.IDTokenSigningAlgValuesSupported) > 0},
+	}
+	if field, ok := firstMissing(required); !ok {
+		return &InvalidDiscoveryError{URL: discoveryURL, Field: field, Err: ErrDiscoveryMissingField}
+	}
+	return nil
+}
+
+type requiredField struct {
+	field   string
+	present bool
+}
+
+// firstMissing returns the first field that isn't present. ok is true if
+// every field is.
+func firstMissing(fields []requiredField) (field string, ok bool) {
+	for _, f := range fields {
+		if !f.present {
+			return f.field, false
+		}
+	}
+	return "", true
+}
+
+// MatchesIssuer reports whether issuer is the issuer of this document or,
+// for a multi-tenant document, the issuer of one of its tenants.
+func (d *DiscoveryData) MatchesIssuer(issuer string) bool {
+	return issuerMatches(d.Issuer, issuer)
+}
+
+// issuerMatches reports whether issuer is expected. A tenant ID placeholder
+// in expected stands for any single path segment, such as "common" or
+// "organizations".
+func issuerMatches(expected, issuer string) bool {
+	before, after, templated := strings.Cut(expected, tenantIDPlaceholder)
+	if !templated {
+		return issuer == expected
+	}
+
+	if !strings.HasPrefix(issuer, before) || !strings.HasSuffix(issuer, after) || len(issuer) <= len(before)+len(after) {
+		return false
+	}
+	tenant := issuer[len(before) : len(issuer)-len(after)]
+	return !strings.Contains(tenant, "/")
+}
+
+func isSecureURL(rawURL string, insecureHosts []string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Scheme == "https" {
+		return true
+	}
+	return u.Scheme == "http" && slices.Contains(insecureHosts, u.Hostname())
+}
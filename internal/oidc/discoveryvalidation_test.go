@@ -0,0 +1,65 @@
+package oidc
+
+import "testing"
+
+func TestIssuerMatches(t *testing.T) {
+	const (
+		plain    = "https://accounts.example.com"
+		template = "https://login.microsoftonline.com/{tenantid}/v2.0"
+	)
+
+	tests := []struct {
+		name     string
+		expected string
+		issuer   string
+		want     bool
+	}{
+		{"exact", plain, plain, true},
+		{"trailing slash", plain, plain + "/", false},
+		{"different host", plain, "https://accounts.example.org", false},
+		{"http", plain, "http://accounts.example.com", false},
+		{"prefix of expected", plain, "https://accounts.example.co", false},
+		{"empty", plain, "", false},
+		{"placeholder is literal without template", plain, "https://accounts.example.com/{tenantid}", false},
+
+		{"tenant", template, "https://login.microsoftonline.com/9188040d-6c67-4c5b-b112-36a304b66dad/v2.0", true},
+		{"empty tenant", template, "https://login.microsoftonline.com//v2.0", false},
+		{"no tenant", template, "https://login.microsoftonline.com/v2.0", false},
+		{"tenant with path", template, "https://login.microsoftonline.com/a/b/v2.0", false},
+		{"wrong suffix", template, "https://login.microsoftonline.com/tenant/v1.0", false},
+		{"wrong host", template, "https://login.evil.com/tenant/v2.0", false},
+		{"host smuggled into tenant", template, "https://login.microsoftonline.com@evil.com/tenant/v2.0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := issuerMatches(tt.expected, tt.issuer); got != tt.want {
+				t.Errorf("issuerMatches(%q, %q) = %v, want %v", tt.expected, tt.issuer, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSecureURL(t *testing.T) {
+	insecureHosts := []string{"localhost"}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://accounts.example.com/authorize", true},
+		{"http://accounts.example.com/authorize", false},
+		{"http://localhost:8080/authorize", true},
+		{"http://localhost.example.com/authorize", false},
+		{"https:///authorize", false},
+		{"/authorize", false},
+		{"ftp://localhost/authorize", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isSecureURL(tt.url, insecureHosts); got != tt.want {
+			t.Errorf("isSecureURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
@@ -3,14 +3,38 @@ package oidc
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/Nick-Anderssohn/oidc-demo/internal/util"
 	"golang.org/x/oauth2"
 )
 
 type Config struct {
 	*oauth2.Config
 	DiscoveryURL string
+
+	// Discovery is the cache the discovery document at DiscoveryURL is
+	// looked up in.
+	Discovery *DiscoveryCache
+
+	// ClockSkew is the leeway allowed when comparing time based claims
+	// against our own clock.
+	ClockSkew time.Duration
+
+	// MaxIssuedAtAge rejects ID tokens whose iat is further in the past than
+	// this, even if they haven't expired yet. Zero disables the check.
+	MaxIssuedAtAge time.Duration
+
+	// MaxAge is the max_age sent in the authentication request. When set,
+	// the ID token must contain an auth_time no older than MaxAge.
+	MaxAge time.Duration
+
+	// AllowedTenantIDs restricts which tenants can sign in with a
+	// multi-tenant provider such as Entra ID. If empty, all tenants are
+	// allowed.
+	AllowedTenantIDs []string
 }
 
 type TokenResponse struct {
@@ -32,20 +56,33 @@ func ExchangeCodeForToken(ctx context.Context, config *Config, code string, opts
 	}
 
 	idTokenStr, ok := token.Extra("id_token").(string)
-	if !ok {
+	if !ok || idTokenStr == "" {
+		return TokenResponse{}, fmt.Errorf("token response did not include an id_token")
+	}
+
+	discoveryData, err := config.Discovery.Get(ctx, config.DiscoveryURL)
+	if err != nil {
 		return TokenResponse{}, err
 	}
 
-	// We don't need to validate the signature of the jwt. According to the OIDC
-	// spec, TLS server validation with the identity provider is sufficient. See
+	// TLS server validation alone is sufficient according to the OIDC spec,
+	// but we verify the signature anyway so that the same validation holds
+	// for ID tokens that travel through the front channel.
 	// https://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation
-	// Since we don't need to worry about the signature, we'll just validate the
-	// payload claims and call it good.
-	claims, err := extractIDTokenPayload(idTokenStr)
+	header, claims, err := verifyIDToken(ctx, discoveryData, idTokenStr)
 	if err != nil {
 		return TokenResponse{}, err
 	}
-	if err := validateIDTokenStandardPayloadClaims(config, claims); err != nil {
+	if err := validateIDTokenStandardPayloadClaims(config, discoveryData, claims); err != nil {
+		return TokenResponse{}, err
+	}
+
+	// Both hashes are optional in the code flow, but if the provider sent
+	// them they have to match what we actually received.
+	if err := ValidateAtHash(header.Alg, claims, token.AccessToken, false); err != nil {
+		return TokenResponse{}, err
+	}
+	if err := ValidateCHash(header.Alg, claims, code, false); err != nil {
 		return TokenResponse{}, err
 	}
 
@@ -57,43 +94,139 @@ func ExchangeCodeForToken(ctx context.Context, config *Config, code string, opts
 }
 
 // https://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation
-func validateIDTokenStandardPayloadClaims(config *Config, claims map[string]any) error {
-	discoveryData, err := GetDiscoveryData(config.DiscoveryURL)
+func validateIDTokenStandardPayloadClaims(config *Config, discoveryData *DiscoveryData, claims map[string]any) error {
+	// Validate Issuer
+	expectedIssuer, err := expectedIssuer(config, discoveryData, claims)
 	if err != nil {
 		return err
 	}
-
-	// Validate Issuer
-	if iss, ok := claims[Iss].(string); !ok || iss != discoveryData.Issuer {
+	if iss, ok := claims[Iss].(string); !ok || iss != expectedIssuer {
 		return fmt.Errorf("invalid issuer: %v", claims[Iss])
 	}
 
-	audAsString, audIsStr := claims[Aud].(string)
-	if audIsStr && audAsString != config.ClientID {
-		return fmt.Errorf("aud does not match client ID. aud: %v", claims[Aud])
+	if err := validateAudience(config, claims); err != nil {
+		return err
 	}
 
-	if !audIsStr {
-		aud, ok := claims[Aud].([]any)
-		if !ok || len(aud) == 0 {
-			return fmt.Errorf("invalid audience: %v", claims[Aud])
-		}
+	return validateTimeClaims(config, claims, time.Now())
+}
+
+// expectedIssuer is normally the issuer from the discovery document. For
+// multi-tenant providers whose discovery issuer is a template, it is the
+// template filled in with the token's tenant ID.
+func expectedIssuer(config *Config, discoveryData *DiscoveryData, claims map[string]any) (string, error) {
+	isTemplate := strings.Contains(discoveryData.Issuer, tenantIDPlaceholder)
+	if !isTemplate && len(config.AllowedTenantIDs) == 0 {
+		return discoveryData.Issuer, nil
+	}
+
+	tid, ok := claims[Tid].(string)
+	if !ok || tid == "" {
+		return "", fmt.Errorf("tid is required for this provider: %v", claims[Tid])
+	}
+
+	if len(config.AllowedTenantIDs) > 0 && !slices.ContainsFunc(config.AllowedTenantIDs, func(allowed string) bool {
+		return strings.EqualFold(allowed, tid)
+	}) {
+		return "", fmt.Errorf("tenant is not allowed: %s", tid)
+	}
 
-		clientIDFound := false
-		for _, a := range aud {
-			if audStr, ok := a.(string); ok && audStr == config.ClientID {
-				clientIDFound = true
-				break
+	return strings.ReplaceAll(discoveryData.Issuer, tenantIDPlaceholder, tid), nil
+}
+
+func validateAudience(config *Config, claims map[string]any) error {
+	var aud []string
+	switch v := claims[Aud].(type) {
+	case string:
+		aud = []string{v}
+	case []any:
+		for _, a := range v {
+			audStr, ok := a.(string)
+			if !ok {
+				return fmt.Errorf("invalid audience: %v", claims[Aud])
 			}
+			aud = append(aud, audStr)
 		}
-		if !clientIDFound {
-			return fmt.Errorf("client ID not found in audience: %v", claims[Aud])
+	}
+
+	if len(aud) == 0 {
+		return fmt.Errorf("invalid audience: %v", claims[Aud])
+	}
+
+	if !util.Contains(aud, config.ClientID) {
+		return fmt.Errorf("client ID not found in audience: %v", claims[Aud])
+	}
+
+	// When there are several audiences, azp tells us which of them the token
+	// was actually issued to, and that has to be us.
+	azp, hasAzp := claims[Azp]
+	if len(aud) > 1 && !hasAzp {
+		return fmt.Errorf("azp is required when there are multiple audiences")
+	}
+	if hasAzp {
+		if azpStr, ok := azp.(string); !ok || azpStr != config.ClientID {
+			return fmt.Errorf("azp does not match client ID. azp: %v", azp)
 		}
 	}
 
-	if exp, ok := claims[Exp].(float64); !ok || int64(exp) < time.Now().Unix() {
+	return nil
+}
+
+func validateTimeClaims(config *Config, claims map[string]any, now time.Time) error {
+	skew := config.ClockSkew
+
+	exp, ok, err := numericDateClaim(claims, Exp)
+	if err != nil || !ok {
+		return fmt.Errorf("invalid exp: %v", claims[Exp])
+	}
+	if now.After(exp.Add(skew)) {
 		return fmt.Errorf("token expired")
 	}
 
+	iat, ok, err := numericDateClaim(claims, Iat)
+	if err != nil || !ok {
+		return fmt.Errorf("invalid iat: %v", claims[Iat])
+	}
+	if iat.After(now.Add(skew)) {
+		return fmt.Errorf("token issued in the future. iat: %v", iat)
+	}
+	if config.MaxIssuedAtAge > 0 && now.Sub(iat) > config.MaxIssuedAtAge+skew {
+		return fmt.Errorf("token issued too long ago. iat: %v", iat)
+	}
+
+	nbf, ok, err := numericDateClaim(claims, Nbf)
+	if err != nil {
+		return fmt.Errorf("invalid nbf: %v", claims[Nbf])
+	}
+	if ok && now.Add(skew).Before(nbf) {
+		return fmt.Errorf("token not valid yet. nbf: %v", nbf)
+	}
+
+	if config.MaxAge > 0 {
+		authTime, ok, err := numericDateClaim(claims, AuthTime)
+		if err != nil || !ok {
+			return fmt.Errorf("auth_time is required when max_age is requested: %v", claims[AuthTime])
+		}
+		if now.Sub(authTime) > config.MaxAge+skew {
+			return fmt.Errorf("authentication too old. auth_time: %v", authTime)
+		}
+	}
+
 	return nil
 }
+
+// numericDateClaim reads a NumericDate claim (seconds since the epoch).
+// ok is false if the claim isn't present.
+func numericDateClaim(claims map[string]any, name string) (t time.Time, ok bool, err error) {
+	raw, present := claims[name]
+	if !present {
+		return time.Time{}, false, nil
+	}
+
+	seconds, isNumber := raw.(float64)
+	if !isNumber {
+		return time.Time{}, false, fmt.Errorf("%s is not a number: %v", name, raw)
+	}
+
+	return time.Unix(int64(seconds), 0), true, nil
+}
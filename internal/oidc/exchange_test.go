@@ -0,0 +1,48 @@
+package oidc
+
+import "testing"
+
+func TestExpectedIssuer(t *testing.T) {
+	const (
+		tenant   = "9188040d-6c67-4c5b-b112-36a304b66dad"
+		template = "https://login.microsoftonline.com/{tenantid}/v2.0"
+	)
+
+	tests := []struct {
+		name           string
+		discoveryIss   string
+		allowedTenants []string
+		tid            any
+		want           string
+		wantErr        bool
+	}{
+		{"single tenant", "https://accounts.example.com", nil, nil, "https://accounts.example.com", false},
+		{"template", template, nil, tenant, "https://login.microsoftonline.com/" + tenant + "/v2.0", false},
+		{"template without tid", template, nil, nil, "", true},
+		{"template with empty tid", template, nil, "", "", true},
+		{"template with non-string tid", template, nil, 42, "", true},
+		{"allowed tenant", template, []string{tenant}, tenant, "https://login.microsoftonline.com/" + tenant + "/v2.0", false},
+		{"allowed tenant ignores case", template, []string{"9188040D-6C67-4C5B-B112-36A304B66DAD"}, tenant, "https://login.microsoftonline.com/" + tenant + "/v2.0", false},
+		{"tenant not allowed", template, []string{"other"}, tenant, "", true},
+		{"allow-list on a single tenant issuer", "https://login.microsoftonline.com/" + tenant + "/v2.0", []string{tenant}, tenant, "https://login.microsoftonline.com/" + tenant + "/v2.0", false},
+		{"allow-list requires tid", "https://login.microsoftonline.com/" + tenant + "/v2.0", []string{tenant}, nil, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{AllowedTenantIDs: tt.allowedTenants}
+			claims := map[string]any{}
+			if tt.tid != nil {
+				claims[Tid] = tt.tid
+			}
+
+			got, err := expectedIssuer(config, &DiscoveryData{Issuer: tt.discoveryIss}, claims)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expectedIssuer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expectedIssuer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package oidc
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheLifetime works out how long a response may be cached for from its
+// Cache-Control max-age directive. ok is false when the response did not
+// specify a max-age. no-store and no-cache are treated as a max-age of zero.
+func cacheLifetime(header http.Header) (lifetime time.Duration, ok bool) {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		if directive == "no-store" || directive == "no-cache" {
+			return 0, true
+		}
+
+		if value, found := strings.CutPrefix(directive, "max-age="); found {
+			seconds, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+			if err != nil || seconds < 0 {
+				continue
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return 0, false
+}
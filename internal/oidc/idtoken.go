@@ -1,10 +1,9 @@
 package oidc
 
 import (
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 )
 
 // Standard claims for OpenID Connect ID Tokens.
@@ -25,6 +24,9 @@ const (
 	// Time at which the JWT was issued.
 	Iat = "iat"
 
+	// Time before which the JWT MUST NOT be accepted.
+	Nbf = "nbf"
+
 	// Time when the End-User authentication occurred.
 	AuthTime = "auth_time"
 
@@ -39,20 +41,45 @@ const (
 
 	// Authorized party: The party to which the ID Token was issued.
 	Azp = "azp"
+
+	// Access Token hash value: binds the ID Token to the Access Token.
+	AtHash = "at_hash"
+
+	// Code hash value: binds the ID Token to the Authorization Code.
+	CHash = "c_hash"
 )
 
-func extractIDTokenPayload(idToken string) (map[string]any, error) {
-	parts := strings.Split(idToken, ".")
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("invalid ID token format")
-	}
-	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+// Claims added by the OpenID Connect logout specifications.
+// https://openid.net/specs/openid-connect-frontchannel-1_0.html#ClaimsContents
+const (
+	// Session ID: Identifies the user's session at the provider.
+	Sid = "sid"
+)
+
+// Microsoft Entra ID specific claims.
+// https://learn.microsoft.com/en-us/entra/identity-platform/id-token-claims-reference
+const (
+	// Tenant ID: The directory (tenant) the user signed in to.
+	Tid = "tid"
+)
+
+// tenantIDPlaceholder appears in the issuer of Entra's multi-tenant
+// discovery documents (the common and organizations endpoints). The real
+// issuer of each token has the user's tenant ID in its place.
+const tenantIDPlaceholder = "{tenantid}"
+
+// verifyIDToken checks the signature of the ID token against the provider's
+// JWKS and returns the decoded payload claims. The token must be signed with
+// one of the algorithms the provider advertises in its discovery document.
+func verifyIDToken(ctx context.Context, discoveryData *DiscoveryData, idToken string) (JOSEHeader, map[string]any, error) {
+	header, payload, err := verifyJWS(ctx, idToken, discoveryData.JwksURI, discoveryData.IDTokenSigningAlgValuesSupported)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode payload: %w", err)
+		return JOSEHeader{}, nil, fmt.Errorf("failed to verify ID token: %w", err)
 	}
+
 	var claims map[string]any
 	if err := json.Unmarshal(payload, &claims); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+		return JOSEHeader{}, nil, fmt.Errorf("failed to unmarshal payload: %w", err)
 	}
-	return claims, nil
+	return header, claims, nil
 }
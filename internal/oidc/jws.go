@@ -0,0 +1,205 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/util"
+)
+
+// JWS algorithms we are able to verify.
+// https://datatracker.ietf.org/doc/html/rfc7518#section-3.1
+const (
+	RS256 = "RS256"
+	RS384 = "RS384"
+	RS512 = "RS512"
+	PS256 = "PS256"
+	ES256 = "ES256"
+	ES384 = "ES384"
+)
+
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// JOSEHeader holds the header members of a JWS that we care about.
+type JOSEHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// jws is a JWS in compact serialization, split into its parts.
+type jws struct {
+	header       JOSEHeader
+	payload      []byte
+	signingInput []byte
+	signature    []byte
+}
+
+func parseJWS(token string) (*jws, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid JWS format")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode header: %w", err)
+	}
+	var header JOSEHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal header: %w", err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode payload: %w", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	return &jws{
+		header:       header,
+		payload:      payload,
+		signingInput: []byte(parts[0] + "." + parts[1]),
+		signature:    signature,
+	}, nil
+}
+
+// verifyJWS checks the signature of a compact JWS against the key set served
+// at jwksURI and returns the verified payload. The alg in the header must be
+// one of allowedAlgs.
+func verifyJWS(ctx context.Context, token, jwksURI string, allowedAlgs []string) (JOSEHeader, []byte, error) {
+	parsed, err := parseJWS(token)
+	if err != nil {
+		return JOSEHeader{}, nil, err
+	}
+
+	alg := parsed.header.Alg
+	if !util.Contains(allowedAlgs, alg) {
+		return JOSEHeader{}, nil, fmt.Errorf("signing algorithm %q is not allowed", alg)
+	}
+	if hashForAlg(alg) == 0 {
+		return JOSEHeader{}, nil, fmt.Errorf("unsupported signing algorithm: %q", alg)
+	}
+
+	candidates, err := JWKSCache.KeysFor(ctx, jwksURI, parsed.header.Kid, alg)
+	if err != nil {
+		return JOSEHeader{}, nil, err
+	}
+	if len(candidates) == 0 {
+		return JOSEHeader{}, nil, fmt.Errorf("no key found for kid %q and alg %q", parsed.header.Kid, alg)
+	}
+
+	for _, candidate := range candidates {
+		key, err := candidate.PublicKey()
+		if err != nil {
+			continue
+		}
+		if verifySignature(alg, key, parsed.signingInput, parsed.signature) == nil {
+			return parsed.header, parsed.payload, nil
+		}
+	}
+
+	return JOSEHeader{}, nil, ErrInvalidSignature
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signingInput, signature []byte) error {
+	hash := hashForAlg(alg)
+	if hash == 0 {
+		return fmt.Errorf("unsupported signing algorithm: %q", alg)
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	switch alg {
+	case RS256, RS384, RS512:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match alg %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+
+	case PS256:
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match alg %s", alg)
+		}
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hash}
+		if err := rsa.VerifyPSS(rsaKey, hash, digest, signature, opts); err != nil {
+			return ErrInvalidSignature
+		}
+		return nil
+
+	case ES256, ES384:
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type does not match alg %s", alg)
+		}
+		if ecKey.Curve.Params().BitSize != curveBitsForAlg(alg) {
+			return fmt.Errorf("key curve does not match alg %s", alg)
+		}
+		// JWS encodes ECDSA signatures as the fixed-width concatenation R || S.
+		// https://datatracker.ietf.org/doc/html/rfc7518#section-3.4
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return ErrInvalidSignature
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported signing algorithm: %q", alg)
+}
+
+func hashForAlg(alg string) crypto.Hash {
+	switch alg {
+	case RS256, PS256, ES256:
+		return crypto.SHA256
+	case RS384, ES384:
+		return crypto.SHA384
+	case RS512:
+		return crypto.SHA512
+	}
+	return 0
+}
+
+func keyTypeForAlg(alg string) string {
+	switch alg {
+	case RS256, RS384, RS512, PS256:
+		return "RSA"
+	case ES256, ES384:
+		return "EC"
+	}
+	return ""
+}
+
+func curveBitsForAlg(alg string) int {
+	switch alg {
+	case ES256:
+		return 256
+	case ES384:
+		return 384
+	}
+	return 0
+}
@@ -0,0 +1,192 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+type testKeys struct {
+	rsa  *rsa.PrivateKey
+	p256 *ecdsa.PrivateKey
+	p384 *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, p256: p256, p384: p384}
+}
+
+// sign produces a JWS signature over signingInput the way an OP would.
+func sign(t *testing.T, alg string, key crypto.Signer, signingInput []byte) []byte {
+	t.Helper()
+
+	hash := hashForAlg(alg)
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if alg == PS256 {
+			sig, err := rsa.SignPSS(rand.Reader, k, hash, digest, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}
+		sig, err := rsa.SignPKCS1v15(rand.Reader, k, hash, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig
+	}
+
+	t.Fatalf("unsupported key type %T", key)
+	return nil
+}
+
+func TestVerifySignature(t *testing.T) {
+	keys := newTestKeys(t)
+	signingInput := []byte("eyJhbGciOiJSUzI1NiJ9.eyJzdWIiOiIxMjMifQ")
+
+	tests := []struct {
+		alg      string
+		signer   crypto.Signer
+		wrongKey crypto.PublicKey
+	}{
+		{RS256, keys.rsa, &keys.p256.PublicKey},
+		{RS384, keys.rsa, &keys.p256.PublicKey},
+		{RS512, keys.rsa, &keys.p384.PublicKey},
+		{PS256, keys.rsa, &keys.p256.PublicKey},
+		{ES256, keys.p256, &keys.p384.PublicKey},
+		{ES384, keys.p384, &keys.p256.PublicKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			signature := sign(t, tt.alg, tt.signer, signingInput)
+			public := tt.signer.Public()
+
+			if err := verifySignature(tt.alg, public, signingInput, signature); err != nil {
+				t.Fatalf("valid signature rejected: %v", err)
+			}
+
+			tamperedInput := append([]byte{}, signingInput...)
+			tamperedInput[len(tamperedInput)-1] ^= 1
+			if err := verifySignature(tt.alg, public, tamperedInput, signature); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("tampered signing input: error = %v, want ErrInvalidSignature", err)
+			}
+
+			tamperedSignature := append([]byte{}, signature...)
+			tamperedSignature[0] ^= 1
+			if err := verifySignature(tt.alg, public, signingInput, tamperedSignature); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("tampered signature: error = %v, want ErrInvalidSignature", err)
+			}
+
+			if err := verifySignature(tt.alg, public, signingInput, signature[:len(signature)-1]); err == nil {
+				t.Error("truncated signature accepted")
+			}
+
+			if err := verifySignature(tt.alg, tt.wrongKey, signingInput, signature); err == nil {
+				t.Error("signature accepted with a key that does not match the alg")
+			}
+		})
+	}
+}
+
+func TestVerifySignatureRejectsAlgConfusion(t *testing.T) {
+	keys := newTestKeys(t)
+	signingInput := []byte("header.payload")
+
+	// A PKCS#1 v1.5 signature must not pass as PSS and vice versa, even
+	// though both use SHA-256 and the same key.
+	rs256 := sign(t, RS256, keys.rsa, signingInput)
+	if err := verifySignature(PS256, &keys.rsa.PublicKey, signingInput, rs256); err == nil {
+		t.Error("RS256 signature accepted as PS256")
+	}
+	ps256 := sign(t, PS256, keys.rsa, signingInput)
+	if err := verifySignature(RS256, &keys.rsa.PublicKey, signingInput, ps256); err == nil {
+		t.Error("PS256 signature accepted as RS256")
+	}
+
+	// ES256 and ES384 are tied to their curves.
+	es256 := sign(t, ES256, keys.p256, signingInput)
+	if err := verifySignature(ES384, &keys.p256.PublicKey, signingInput, es256); err == nil {
+		t.Error("P-256 key accepted for ES384")
+	}
+
+	for _, alg := range []string{"none", "HS256", "ES512", ""} {
+		if err := verifySignature(alg, &keys.rsa.PublicKey, signingInput, rs256); err == nil {
+			t.Errorf("unsupported alg %q accepted", alg)
+		}
+	}
+}
+
+func TestParseJWS(t *testing.T) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"k1"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"123"}`))
+	signature := base64.RawURLEncoding.EncodeToString([]byte("sig"))
+
+	parsed, err := parseJWS(header + "." + payload + "." + signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.header.Alg != RS256 || parsed.header.Kid != "k1" {
+		t.Errorf("header = %+v", parsed.header)
+	}
+	if string(parsed.payload) != `{"sub":"123"}` {
+		t.Errorf("payload = %s", parsed.payload)
+	}
+	if string(parsed.signingInput) != header+"."+payload {
+		t.Errorf("signing input = %s", parsed.signingInput)
+	}
+	if string(parsed.signature) != "sig" {
+		t.Errorf("signature = %s", parsed.signature)
+	}
+
+	invalid := map[string]string{
+		"two parts":          header + "." + payload,
+		"four parts":         header + "." + payload + "." + signature + ".x",
+		"header not base64":  "!!." + payload + "." + signature,
+		"header not json":    base64.RawURLEncoding.EncodeToString([]byte("nope")) + "." + payload + "." + signature,
+		"payload not base64": header + ".!!." + signature,
+		"padded signature":   header + "." + payload + "." + signature + "=",
+	}
+	for name, token := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseJWS(token); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
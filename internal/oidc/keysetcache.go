@@ -0,0 +1,126 @@
+package oidc
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// KeySetCache caches JSON Web Key Sets by jwks_uri.
+//
+// Key sets are kept for as long as the provider's Cache-Control max-age
+// allows. When a token arrives signed with a kid we haven't seen, the key set
+// is refetched in case the provider rotated its keys, but at most once per
+// RefetchCooldown so that a burst of callbacks (or a bogus kid) can't cause a
+// flood of requests to the provider. If the provider can't be reached, the
+// last good key set keeps being served.
+type KeySetCache struct {
+	// DefaultTTL is used when the provider doesn't send a max-age.
+	DefaultTTL time.Duration
+
+	// RefetchCooldown is the minimum time between two fetches of the same
+	// key set. It is also the minimum TTL of a cached key set.
+	RefetchCooldown time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*keySetEntry
+}
+
+type keySetEntry struct {
+	// mu is held while reading or fetching the key set. Holding it during the
+	// fetch means concurrent callers wait for one fetch rather than each
+	// starting their own.
+	mu          sync.Mutex
+	keySet      *JSONWebKeySet
+	validUntil  time.Time
+	lastFetched time.Time
+}
+
+// NewKeySetCache creates an empty KeySetCache.
+func NewKeySetCache(defaultTTL, refetchCooldown time.Duration) *KeySetCache {
+	return &KeySetCache{
+		DefaultTTL:      defaultTTL,
+		RefetchCooldown: refetchCooldown,
+		entries:         map[string]*keySetEntry{},
+	}
+}
+
+// KeysFor returns the keys served at jwksURI that match kid and alg,
+// fetching or refreshing the key set as needed.
+func (c *KeySetCache) KeysFor(ctx context.Context, jwksURI, kid, alg string) ([]JSONWebKey, error) {
+	entry := c.entry(jwksURI)
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	now := time.Now()
+
+	if entry.keySet == nil || now.After(entry.validUntil) {
+		if err := c.refresh(ctx, jwksURI, entry); err != nil {
+			return nil, err
+		}
+		return entry.keySet.KeysFor(kid, alg), nil
+	}
+
+	keys := entry.keySet.KeysFor(kid, alg)
+	if len(keys) > 0 {
+		return keys, nil
+	}
+
+	// Unknown kid. The provider may have rotated its keys since we last
+	// fetched them.
+	if now.Sub(entry.lastFetched) < c.RefetchCooldown {
+		return nil, nil
+	}
+	if err := c.refresh(ctx, jwksURI, entry); err != nil {
+		return nil, err
+	}
+	return entry.keySet.KeysFor(kid, alg), nil
+}
+
+// refresh fetches the key set for entry. Must be called with entry.mu held.
+// If the fetch fails and a previous key set is available, the previous key
+// set is kept and no error is returned.
+func (c *KeySetCache) refresh(ctx context.Context, jwksURI string, entry *keySetEntry) error {
+	now := time.Now()
+	entry.lastFetched = now
+
+	keySet, lifetime, err := fetchJSONWebKeySet(ctx, jwksURI)
+	if err != nil {
+		if entry.keySet == nil {
+			return err
+		}
+
+		log.Printf("could not refresh jwks from %s, serving last known key set: %v", jwksURI, err)
+		entry.validUntil = now.Add(c.RefetchCooldown)
+		return nil
+	}
+
+	if lifetime < 0 {
+		lifetime = c.DefaultTTL
+	}
+	if lifetime < c.RefetchCooldown {
+		lifetime = c.RefetchCooldown
+	}
+
+	entry.keySet = keySet
+	entry.validUntil = now.Add(lifetime)
+	return nil
+}
+
+func (c *KeySetCache) entry(jwksURI string) *keySetEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = map[string]*keySetEntry{}
+	}
+
+	entry, ok := c.entries[jwksURI]
+	if !ok {
+		entry = &keySetEntry{}
+		c.entries[jwksURI] = entry
+	}
+	return entry
+}
@@ -0,0 +1,76 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// EndSessionRequest is an RP-Initiated Logout request. The browser is sent to
+// its URL to end the user's session at the provider, and the provider then
+// redirects it back to PostLogoutRedirectURI with State.
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html#RPLogout
+type EndSessionRequest struct {
+	// IDTokenHint is the ID token the session was logged in with. It tells
+	// the provider which of its sessions to end.
+	IDTokenHint string
+
+	ClientID              string
+	PostLogoutRedirectURI string
+	State                 string
+}
+
+// URL builds the logout URL for the provider's end_session_endpoint.
+func (r *EndSessionRequest) URL(endSessionEndpoint string) (string, error) {
+	endpoint, err := url.Parse(endSessionEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid end_session_endpoint: %w", err)
+	}
+
+	query := endpoint.Query()
+	if r.IDTokenHint != "" {
+		query.Set("id_token_hint", r.IDTokenHint)
+	}
+	if r.ClientID != "" {
+		query.Set("client_id", r.ClientID)
+	}
+	if r.PostLogoutRedirectURI != "" {
+		query.Set("post_logout_redirect_uri", r.PostLogoutRedirectURI)
+	}
+	if r.State != "" {
+		query.Set("state", r.State)
+	}
+	endpoint.RawQuery = query.Encode()
+
+	return endpoint.String(), nil
+}
+
+// ValidateFrontChannelLogoutIssuer checks the iss parameter of a
+// Front-Channel Logout request. For multi-tenant providers whose discovery
+// issuer is a template, the tenant is taken from iss and must be allowed.
+// https://openid.net/specs/openid-connect-frontchannel-1_0.html#RPLogout
+func ValidateFrontChannelLogoutIssuer(ctx context.Context, config *Config, iss string) error {
+	discoveryData, err := config.Discovery.Get(ctx, config.DiscoveryURL)
+	if err != nil {
+		return err
+	}
+
+	expected := discoveryData.Issuer
+	if prefix, suffix, isTemplate := strings.Cut(discoveryData.Issuer, tenantIDPlaceholder); isTemplate {
+		claims := map[string]any{}
+		if strings.HasPrefix(iss, prefix) && strings.HasSuffix(iss, suffix) && len(iss) > len(prefix)+len(suffix) {
+			claims[Tid] = iss[len(prefix) : len(iss)-len(suffix)]
+		}
+
+		expected, err = expectedIssuer(config, discoveryData, claims)
+		if err != nil {
+			return err
+		}
+	}
+
+	if iss != expected {
+		return fmt.Errorf("invalid issuer: %s", iss)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Claims specific to Back-Channel Logout tokens.
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#LogoutToken
+const (
+	// JWT ID: A unique identifier for the token, used to detect replays.
+	Jti = "jti"
+
+	// Events: Declares that the JWT is a Logout Token.
+	Events = "events"
+)
+
+// BackChannelLogoutEvent is the member of the events claim that identifies a
+// Logout Token.
+const BackChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// LogoutToken is a validated Back-Channel Logout token. At least one of Sub
+// and Sid is set.
+type LogoutToken struct {
+	Sub string
+	Sid string
+	Jti string
+
+	Claims map[string]any
+}
+
+// ValidateLogoutToken verifies a Back-Channel Logout token the provider POSTed
+// to us. Checking that the jti hasn't been seen before is up to the caller.
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#Validation
+func ValidateLogoutToken(ctx context.Context, config *Config, logoutToken string) (LogoutToken, error) {
+	discoveryData, err := config.Discovery.Get(ctx, config.DiscoveryURL)
+	if err != nil {
+		return LogoutToken{}, err
+	}
+
+	_, payload, err := verifyJWS(ctx, logoutToken, discoveryData.JwksURI, discoveryData.IDTokenSigningAlgValuesSupported)
+	if err != nil {
+		return LogoutToken{}, fmt.Errorf("failed to verify logout token: %w", err)
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return LogoutToken{}, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+
+	// iss, aud, iat and exp are validated the same way as in ID tokens. There
+	// is no auth_time, so max_age doesn't apply.
+	tokenConfig := *config
+	tokenConfig.MaxAge = 0
+	if err := validateIDTokenStandardPayloadClaims(&tokenConfig, discoveryData, claims); err != nil {
+		return LogoutToken{}, err
+	}
+
+	events, ok := claims[Events].(map[string]any)
+	if !ok {
+		return LogoutToken{}, fmt.Errorf("invalid events: %v", claims[Events])
+	}
+	if _, ok := events[BackChannelLogoutEvent].(map[string]any); !ok {
+		return LogoutToken{}, fmt.Errorf("events does not contain a back-channel logout event")
+	}
+
+	// A nonce would mean this is an ID token being passed off as a logout
+	// token.
+	if _, hasNonce := claims[Nonce]; hasNonce {
+		return LogoutToken{}, fmt.Errorf("logout token must not contain a nonce")
+	}
+
+	jti, ok := claims[Jti].(string)
+	if !ok || jti == "" {
+		return LogoutToken{}, fmt.Errorf("invalid jti: %v", claims[Jti])
+	}
+
+	sub, _ := claims[Sub].(string)
+	sid, _ := claims[Sid].(string)
+	if sub == "" && sid == "" {
+		return LogoutToken{}, fmt.Errorf("logout token must contain sub or sid")
+	}
+
+	return LogoutToken{
+		Sub:    sub,
+		Sid:    sid,
+		Jti:    jti,
+		Claims: claims,
+	}, nil
+}
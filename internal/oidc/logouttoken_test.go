@@ -0,0 +1,167 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// logoutTokenProvider serves a discovery document and a JWKS over TLS, and
+// signs logout tokens with the key in the JWKS.
+type logoutTokenProvider struct {
+	*httptest.Server
+	keys testKeys
+}
+
+func newLogoutTokenProvider(t *testing.T) *logoutTokenProvider {
+	t.Helper()
+
+	p := &logoutTokenProvider{keys: newTestKeys(t)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(wellKnownOpenIDConfiguration, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(DiscoveryData{
+			Issuer:                           p.URL,
+			AuthorizationEndpoint:            p.URL + "/authorize",
+			TokenEndpoint:                    p.URL + "/token",
+			JwksURI:                          p.URL + "/jwks",
+			ResponseTypesSupported:           []string{"code"},
+			SubjectTypesSupported:            []string{"public"},
+			IDTokenSigningAlgValuesSupported: []string{RS256},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		key := p.keys.rsa.PublicKey
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(JSONWebKeySet{Keys: []JSONWebKey{{
+			Kty: "RSA",
+			Kid: "test",
+			Use: "sig",
+			Alg: RS256,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	p.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(p.Close)
+
+	previousClient := HTTPClient
+	HTTPClient = *p.Client()
+	t.Cleanup(func() { HTTPClient = previousClient })
+
+	return p
+}
+
+func (p *logoutTokenProvider) config() *Config {
+	return &Config{
+		Config:       &oauth2.Config{ClientID: "client"},
+		DiscoveryURL: p.URL + wellKnownOpenIDConfiguration,
+		Discovery:    NewDiscoveryCache(time.Hour, time.Minute, time.Hour, 0),
+	}
+}
+
+// claims returns the claims of a valid logout token for a session.
+func (p *logoutTokenProvider) claims() map[string]any {
+	now := time.Now()
+	return map[string]any{
+		Iss:    p.URL,
+		Aud:    "client",
+		Iat:    now.Unix(),
+		Exp:    now.Add(2 * time.Minute).Unix(),
+		Jti:    "jti",
+		Sub:    "subject",
+		Sid:    "session",
+		Events: map[string]any{BackChannelLogoutEvent: map[string]any{}},
+	}
+}
+
+func (p *logoutTokenProvider) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": RS256, "kid": "test", "typ": "logout+jwt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature := sign(t, RS256, p.keys.rsa, []byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestValidateLogoutToken(t *testing.T) {
+	p := newLogoutTokenProvider(t)
+	config := p.config()
+
+	tests := []struct {
+		name    string
+		modify  func(claims map[string]any)
+		wantErr bool
+		wantSub string
+		wantSid string
+	}{
+		{"sid and sub", func(map[string]any) {}, false, "subject", "session"},
+		{"sid only", func(c map[string]any) { delete(c, Sub) }, false, "", "session"},
+		{"sub only", func(c map[string]any) { delete(c, Sid) }, false, "subject", ""},
+		{"neither sid nor sub", func(c map[string]any) { delete(c, Sub); delete(c, Sid) }, true, "", ""},
+		{"empty sid and sub", func(c map[string]any) { c[Sub] = ""; c[Sid] = "" }, true, "", ""},
+		{"nonce", func(c map[string]any) { c[Nonce] = "nonce" }, true, "", ""},
+		{"empty nonce", func(c map[string]any) { c[Nonce] = "" }, true, "", ""},
+		{"no events", func(c map[string]any) { delete(c, Events) }, true, "", ""},
+		{"events is not an object", func(c map[string]any) { c[Events] = BackChannelLogoutEvent }, true, "", ""},
+		{"events without the logout event", func(c map[string]any) {
+			c[Events] = map[string]any{"http://schemas.openid.net/event/other": map[string]any{}}
+		}, true, "", ""},
+		{"logout event is not an object", func(c map[string]any) {
+			c[Events] = map[string]any{BackChannelLogoutEvent: true}
+		}, true, "", ""},
+		{"no jti", func(c map[string]any) { delete(c, Jti) }, true, "", ""},
+		{"wrong issuer", func(c map[string]any) { c[Iss] = "https://other.example.com" }, true, "", ""},
+		{"wrong audience", func(c map[string]any) { c[Aud] = "other" }, true, "", ""},
+		{"expired", func(c map[string]any) { c[Exp] = time.Now().Add(-time.Hour).Unix() }, true, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := p.claims()
+			tt.modify(claims)
+
+			token, err := ValidateLogoutToken(context.Background(), config, p.sign(t, claims))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateLogoutToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if token.Sub != tt.wantSub || token.Sid != tt.wantSid || token.Jti != "jti" {
+				t.Errorf("ValidateLogoutToken() = %+v", token)
+			}
+		})
+	}
+}
+
+func TestValidateLogoutTokenRejectsBadSignature(t *testing.T) {
+	p := newLogoutTokenProvider(t)
+
+	token := p.sign(t, p.claims())
+	tampered := token[:len(token)-4] + "AAAA"
+	if token[len(token)-4:] == "AAAA" {
+		tampered = token[:len(token)-4] + "BBBB"
+	}
+
+	if _, err := ValidateLogoutToken(context.Background(), p.config(), tampered); err == nil {
+		t.Error("ValidateLogoutToken() accepted a token with a bad signature")
+	}
+}
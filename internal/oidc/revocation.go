@@ -0,0 +1,84 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Token type hints for RevokeToken.
+// https://datatracker.ietf.org/doc/html/rfc7009#section-2.1
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// RevocationError is returned when the revocation endpoint rejects a request.
+type RevocationError struct {
+	StatusCode int
+	ErrorCode  string
+}
+
+func (e *RevocationError) Error() string {
+	if e.ErrorCode != "" {
+		return fmt.Sprintf("token revocation failed with status %d: %s", e.StatusCode, e.ErrorCode)
+	}
+	return fmt.Sprintf("token revocation failed with status %d", e.StatusCode)
+}
+
+// Temporary reports whether trying again later might succeed. Anything but
+// a server error or rate limiting means the request itself was rejected.
+func (e *RevocationError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// RevokeToken asks the provider to revoke a token. Revoking a refresh token
+// normally revokes the access tokens issued with it too. The client
+// credentials are sent in the request body, which every provider we support
+// accepts. An already invalid token still counts as a success.
+// https://datatracker.ietf.org/doc/html/rfc7009#section-2
+func RevokeToken(ctx context.Context, revocationEndpoint string, config *oauth2.Config, token, tokenTypeHint string) error {
+	form := url.Values{
+		"token":     {token},
+		"client_id": {config.ClientID},
+	}
+	if tokenTypeHint != "" {
+		form.Set("token_type_hint", tokenTypeHint)
+	}
+	if config.ClientSecret != "" {
+		form.Set("client_secret", config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, revocationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	revocationErr := &RevocationError{StatusCode: resp.StatusCode}
+
+	var errorResp struct {
+		Error string `json:"error"`
+	}
+	if json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&errorResp) == nil {
+		revocationErr.ErrorCode = errorResp.Error
+	}
+
+	return revocationErr
+}
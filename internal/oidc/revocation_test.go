@@ -0,0 +1,90 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestRevokeToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		body          string
+		wantErr       bool
+		wantErrorCode string
+		wantTemporary bool
+	}{
+		{"revoked", http.StatusOK, "", false, "", false},
+		{"invalid token", http.StatusBadRequest, `{"error":"invalid_token"}`, true, "invalid_token", false},
+		{"unsupported token type", http.StatusBadRequest, `{"error":"unsupported_token_type"}`, true, "unsupported_token_type", false},
+		{"invalid client", http.StatusUnauthorized, `{"error":"invalid_client"}`, true, "invalid_client", false},
+		{"rate limited", http.StatusTooManyRequests, "", true, "", true},
+		{"unavailable", http.StatusServiceUnavailable, "<html>down</html>", true, "", true},
+		{"server error", http.StatusInternalServerError, "", true, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.PostFormValue("token") != "the-token" ||
+					r.PostFormValue("token_type_hint") != TokenTypeHintRefreshToken ||
+					r.PostFormValue("client_id") != "client" ||
+					r.PostFormValue("client_secret") != "secret" {
+					t.Errorf("unexpected revocation request: %v", r.PostForm)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			config := &oauth2.Config{ClientID: "client", ClientSecret: "secret"}
+			err := RevokeToken(context.Background(), srv.URL, config, "the-token", TokenTypeHintRefreshToken)
+
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("RevokeToken() error = %v", err)
+				}
+				return
+			}
+
+			var revocationErr *RevocationError
+			if !errors.As(err, &revocationErr) {
+				t.Fatalf("RevokeToken() error = %v, want a RevocationError", err)
+			}
+			if revocationErr.StatusCode != tt.status || revocationErr.ErrorCode != tt.wantErrorCode {
+				t.Errorf("error = %+v, want status %d and code %q", revocationErr, tt.status, tt.wantErrorCode)
+			}
+			if revocationErr.Temporary() != tt.wantTemporary {
+				t.Errorf("Temporary() = %v, want %v", revocationErr.Temporary(), tt.wantTemporary)
+			}
+		})
+	}
+}
+
+func TestRevokeTokenTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := RevokeToken(ctx, srv.URL, &oauth2.Config{ClientID: "client"}, "the-token", "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RevokeToken() error = %v, want context.DeadlineExceeded", err)
+	}
+
+	var revocationErr *RevocationError
+	if errors.As(err, &revocationErr) {
+		t.Error("a timeout is not a rejection by the provider")
+	}
+}
@@ -0,0 +1,64 @@
+package oidc
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+)
+
+// ValidateAtHash checks the at_hash claim of an ID token against the access
+// token it was issued with. alg is the alg from the ID token's JOSE header.
+// If required is false, a missing at_hash is accepted.
+// https://openid.net/specs/openid-connect-core-1_0.html#CodeIDToken
+func ValidateAtHash(alg string, claims map[string]any, accessToken string, required bool) error {
+	return validateTokenHash(alg, claims, AtHash, accessToken, required)
+}
+
+// ValidateCHash checks the c_hash claim of an ID token against the
+// authorization code it was issued with. c_hash is required for ID tokens
+// returned from the authorization endpoint in the hybrid flow.
+// https://openid.net/specs/openid-connect-core-1_0.html#HybridIDToken
+func ValidateCHash(alg string, claims map[string]any, code string, required bool) error {
+	return validateTokenHash(alg, claims, CHash, code, required)
+}
+
+func validateTokenHash(alg string, claims map[string]any, claimName, value string, required bool) error {
+	raw, present := claims[claimName]
+	if !present {
+		if required {
+			return fmt.Errorf("%s is missing", claimName)
+		}
+		return nil
+	}
+
+	claimed, ok := raw.(string)
+	if !ok || claimed == "" {
+		return fmt.Errorf("invalid %s: %v", claimName, raw)
+	}
+
+	expected, err := leftHalfHash(alg, value)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claimed), []byte(expected)) != 1 {
+		return fmt.Errorf("%s does not match", claimName)
+	}
+
+	return nil
+}
+
+// leftHalfHash hashes value with the hash function used by alg and returns
+// the base64url encoding of the left-most half of the digest.
+func leftHalfHash(alg, value string) (string, error) {
+	hash := hashForAlg(alg)
+	if hash == 0 {
+		return "", fmt.Errorf("unsupported signing algorithm: %q", alg)
+	}
+
+	h := hash.New()
+	h.Write([]byte(value))
+	digest := h.Sum(nil)
+
+	return base64.RawURLEncoding.EncodeToString(digest[:len(digest)/2]), nil
+}
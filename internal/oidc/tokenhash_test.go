@@ -0,0 +1,86 @@
+package oidc
+
+import "testing"
+
+// The SHA-256 values are the examples from OpenID Connect Core 1.0,
+// Appendix A.3 and A.4.
+const (
+	exampleAccessToken = "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y"
+	exampleCode        = "Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk"
+)
+
+func TestLeftHalfHash(t *testing.T) {
+	tests := []struct {
+		alg   string
+		value string
+		want  string
+	}{
+		{RS256, exampleAccessToken, "77QmUPtjPfzWtF2AnpK9RQ"},
+		{RS256, exampleCode, "LDktKdoQak3Pk0cnXxCltA"},
+		{PS256, exampleAccessToken, "77QmUPtjPfzWtF2AnpK9RQ"},
+		{ES256, exampleCode, "LDktKdoQak3Pk0cnXxCltA"},
+		{RS384, exampleAccessToken, "jtAeDp945y1dDqU3nkIVGNZP1HjH_MFs"},
+		{ES384, exampleCode, "Mq-knyaEMtWGfnBi2POEZb1kiLx10_DF"},
+		{RS512, exampleAccessToken, "q7nS86GgvvFaZkzALLWqJYaJIKw2wCDAVfCAsm5CrBM"},
+		{RS512, exampleCode, "E9z1C-c0Az4eTEzE0Nm3OQ3BS2BhMgxuP7x5JAQj1_4"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg+"/"+tt.want, func(t *testing.T) {
+			got, err := leftHalfHash(tt.alg, tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("leftHalfHash() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, alg := range []string{"none", "HS256", ""} {
+		if _, err := leftHalfHash(alg, exampleAccessToken); err == nil {
+			t.Errorf("leftHalfHash(%q) succeeded, want an error", alg)
+		}
+	}
+}
+
+func TestValidateAtHash(t *testing.T) {
+	tests := []struct {
+		name     string
+		claims   map[string]any
+		token    string
+		required bool
+		wantErr  bool
+	}{
+		{"matches", map[string]any{AtHash: "77QmUPtjPfzWtF2AnpK9RQ"}, exampleAccessToken, true, false},
+		{"missing and optional", map[string]any{}, exampleAccessToken, false, false},
+		{"missing and required", map[string]any{}, exampleAccessToken, true, true},
+		{"does not match", map[string]any{AtHash: "77QmUPtjPfzWtF2AnpK9RQ"}, "another-token", false, true},
+		{"empty", map[string]any{AtHash: ""}, exampleAccessToken, false, true},
+		{"not a string", map[string]any{AtHash: 42}, exampleAccessToken, false, true},
+		{"padded", map[string]any{AtHash: "77QmUPtjPfzWtF2AnpK9RQ=="}, exampleAccessToken, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAtHash(RS256, tt.claims, tt.token, tt.required)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAtHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCHash(t *testing.T) {
+	claims := map[string]any{CHash: "LDktKdoQak3Pk0cnXxCltA"}
+
+	if err := ValidateCHash(RS256, claims, exampleCode, true); err != nil {
+		t.Errorf("valid c_hash rejected: %v", err)
+	}
+	if err := ValidateCHash(RS384, claims, exampleCode, true); err == nil {
+		t.Error("c_hash accepted with the wrong hash function")
+	}
+	if err := ValidateCHash(RS256, map[string]any{AtHash: "LDktKdoQak3Pk0cnXxCltA"}, exampleCode, true); err == nil {
+		t.Error("at_hash accepted in place of c_hash")
+	}
+}
@@ -0,0 +1,150 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrUserInfoNotSupported is returned when the provider's discovery
+	// document doesn't advertise a userinfo_endpoint.
+	ErrUserInfoNotSupported = errors.New("provider has no userinfo endpoint")
+
+	// ErrSubjectMismatch is returned when the UserInfo response is about a
+	// different user than the ID token.
+	ErrSubjectMismatch = errors.New("userinfo sub does not match ID token sub")
+)
+
+// maxUserInfoSize caps how much of a UserInfo response we are willing to read.
+const maxUserInfoSize = 1 << 20
+
+// idTokenOnlyClaims describe the ID token itself rather than the user, so
+// they are never taken from a UserInfo response.
+var idTokenOnlyClaims = map[string]bool{
+	Iss:      true,
+	Sub:      true,
+	Aud:      true,
+	Exp:      true,
+	Iat:      true,
+	Nbf:      true,
+	AuthTime: true,
+	Nonce:    true,
+	Acr:      true,
+	Amr:      true,
+	Azp:      true,
+	AtHash:   true,
+	CHash:    true,
+	Tid:      true,
+
+	// sid identifies the provider session the ID token was issued in, which
+	// logout requests are matched against.
+	Sid: true,
+}
+
+// FetchUserInfo calls the provider's UserInfo endpoint with the access token
+// and returns the claims about the user. Both plain JSON and signed JWT
+// (application/jwt) responses are accepted. The sub in the response must
+// equal expectedSub, the sub of the ID token.
+// https://openid.net/specs/openid-connect-core-1_0.html#UserInfo
+func FetchUserInfo(ctx context.Context, config *Config, accessToken, expectedSub string) (map[string]any, error) {
+	discoveryData, err := config.Discovery.Get(ctx, config.DiscoveryURL)
+	if err != nil {
+		return nil, err
+	}
+	if discoveryData.UserInfoEndpoint == "" {
+		return nil, ErrUserInfoNotSupported
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryData.UserInfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json, application/jwt")
+
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from userinfo endpoint: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxUserInfoSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read userinfo response: %w", err)
+	}
+
+	var claims map[string]any
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/jwt" {
+		claims, err = verifyUserInfoJWT(ctx, config, discoveryData, strings.TrimSpace(string(body)))
+	} else {
+		err = json.Unmarshal(body, &claims)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid userinfo response: %w", err)
+	}
+
+	// The sub has to be checked before anything else in the response is used,
+	// otherwise a substituted response could attach someone else's claims.
+	// https://openid.net/specs/openid-connect-core-1_0.html#UserInfoResponse
+	if sub, ok := claims[Sub].(string); !ok || sub != expectedSub {
+		return nil, ErrSubjectMismatch
+	}
+
+	return claims, nil
+}
+
+// verifyUserInfoJWT checks a signed UserInfo response. Unlike plain JSON
+// responses, these must carry iss and aud, which are validated the same way
+// as in an ID token. Encrypted responses are not supported.
+func verifyUserInfoJWT(ctx context.Context, config *Config, discoveryData *DiscoveryData, token string) (map[string]any, error) {
+	if strings.Count(token, ".") != 2 {
+		return nil, fmt.Errorf("encrypted userinfo responses are not supported")
+	}
+
+	_, payload, err := verifyJWS(ctx, token, discoveryData.JwksURI, discoveryData.UserInfoSigningAlgValuesSupported)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify userinfo JWT: %w", err)
+	}
+
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+
+	expectedIssuer, err := expectedIssuer(config, discoveryData, claims)
+	if err != nil {
+		return nil, err
+	}
+	if iss, ok := claims[Iss].(string); !ok || iss != expectedIssuer {
+		return nil, fmt.Errorf("invalid issuer: %v", claims[Iss])
+	}
+	if err := validateAudience(config, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// MergeUserInfo copies the claims from a UserInfo response into the ID token
+// claims. UserInfo wins for claims about the user, such as name and picture,
+// since it is the more complete source. Claims that only make sense in the ID
+// token are left untouched.
+func MergeUserInfo(idTokenClaims, userInfo map[string]any) {
+	for claim, value := range userInfo {
+		if idTokenOnlyClaims[claim] {
+			continue
+		}
+		idTokenClaims[claim] = value
+	}
+}
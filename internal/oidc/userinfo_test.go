@@ -0,0 +1,37 @@
+package oidc
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestMergeUserInfo(t *testing.T) {
+	idTokenClaims := map[string]any{
+		Iss:     "https://accounts.example.com",
+		Sub:     "user-1",
+		Aud:     "client",
+		Sid:     "provider-session-1",
+		Tid:     "tenant-1",
+		Nonce:   "nonce",
+		"name":  "Old Name",
+		"email": "user@example.com",
+	}
+	want := maps.Clone(idTokenClaims)
+	want["name"] = "New Name"
+	want["picture"] = "https://example.com/picture.png"
+
+	MergeUserInfo(idTokenClaims, map[string]any{
+		Iss:       "https://evil.example.com",
+		Sub:       "user-2",
+		Aud:       "other-client",
+		Sid:       "provider-session-2",
+		Tid:       "tenant-2",
+		Nonce:     "other-nonce",
+		"name":    "New Name",
+		"picture": "https://example.com/picture.png",
+	})
+
+	if !maps.Equal(idTokenClaims, want) {
+		t.Errorf("merged claims = %v, want %v", idTokenClaims, want)
+	}
+}
@@ -0,0 +1,168 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// IssuerRel is the WebFinger link relation of an OpenID Provider's issuer.
+// https://openid.net/specs/openid-connect-discovery-1_0.html#IssuerDiscovery
+const IssuerRel = "http://openid.net/specs/connect/1.0/issuer"
+
+// maxWebFingerResponseSize caps how much of a WebFinger response is read.
+const maxWebFingerResponseSize = 64 << 10
+
+var (
+	// ErrInvalidEmail is returned when an email address can't be turned into
+	// a WebFinger resource.
+	ErrInvalidEmail = errors.New("invalid email address")
+
+	// ErrIssuerNotFound is returned when the email's domain doesn't name an
+	// issuer through WebFinger.
+	ErrIssuerNotFound = errors.New("no issuer found for email")
+
+	// ErrNonPublicAddress is returned when the email's domain resolves to a
+	// loopback, private or link-local address.
+	ErrNonPublicAddress = errors.New("host does not resolve to a public address")
+)
+
+// webFingerClient is used for WebFinger requests to hosts that aren't in the
+// insecure hosts allow-list. The host comes from whatever email the user
+// typed, so it refuses to connect to anything but public addresses. The
+// check runs on the address actually dialed, so a DNS answer that changes
+// between lookups can't get around it. Redirects are not followed.
+var webFingerClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: refuseNonPublicAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webFingerResponse is a JSON Resource Descriptor. Only the members needed to
+// find the issuer are modeled.
+// https://datatracker.ietf.org/doc/html/rfc7033#section-4.4
+type webFingerResponse struct {
+	Subject string `json:"subject"`
+	Links   []struct {
+		Rel  string `json:"rel"`
+		Href string `json:"href"`
+	} `json:"links"`
+}
+
+// LookupIssuer asks the domain of email, through WebFinger, which issuer the
+// user signs in with. The request goes to the host the user typed, so it must
+// be a domain name that resolves to a public address, and it must use https.
+// Hosts in insecureHosts are exempt, which is meant for providers running
+// locally during development.
+//
+// The issuer returned is whatever the domain claims. Callers must only use
+// it to pick among issuers they already trust, never fetch anything from it.
+// https://openid.net/specs/openid-connect-discovery-1_0.html#IssuerDiscovery
+func LookupIssuer(ctx context.Context, email string, insecureHosts []string) (string, error) {
+	email = strings.TrimSpace(email)
+	email = strings.TrimPrefix(email, "acct:")
+
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return "", ErrInvalidEmail
+	}
+
+	host := strings.ToLower(email[at+1:])
+	if strings.ContainsAny(host, ":/?#[]%\\ ") || net.ParseIP(host) != nil {
+		return "", ErrInvalidEmail
+	}
+
+	scheme := "https"
+	client := webFingerClient
+	if slices.Contains(insecureHosts, host) {
+		scheme = "http"
+		client = &HTTPClient
+	}
+
+	webFingerURL := url.URL{
+		Scheme: scheme,
+		Host:   host,
+		Path:   "/.well-known/webfinger",
+		RawQuery: url.Values{
+			"resource": {"acct:" + email},
+			"rel":      {IssuerRel},
+		}.Encode(),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, webFingerURL.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/jrd+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrIssuerNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status from webfinger: %s", resp.Status)
+	}
+
+	var jrd webFingerResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxWebFingerResponseSize)).Decode(&jrd); err != nil {
+		return "", fmt.Errorf("failed to decode webfinger response: %w", err)
+	}
+
+	for _, link := range jrd.Links {
+		if link.Rel == IssuerRel && link.Href != "" {
+			return link.Href, nil
+		}
+	}
+
+	return "", ErrIssuerNotFound
+}
+
+// refuseNonPublicAddress is a net.Dialer Control function that only lets
+// connections to public unicast addresses through.
+func refuseNonPublicAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%s: %w", address, ErrNonPublicAddress)
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which isn't covered by
+// netip.Addr.IsPrivate.
+// https://datatracker.ietf.org/doc/html/rfc6598
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() &&
+		!addr.IsPrivate() &&
+		!addr.IsLoopback() &&
+		!addr.IsLinkLocalUnicast() &&
+		!sharedAddressSpace.Contains(addr)
+}
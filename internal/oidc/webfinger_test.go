@@ -0,0 +1,70 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestLookupIssuerRejectsInvalidEmails(t *testing.T) {
+	emails := []string{
+		"",
+		"user",
+		"@example.com",
+		"user@",
+		"user@127.0.0.1",
+		"user@[::1]",
+		"user@example.com:8080",
+		"user@example.com/path",
+		"user@example.com%2F",
+	}
+
+	for _, email := range emails {
+		if _, err := LookupIssuer(context.Background(), email, nil); !errors.Is(err, ErrInvalidEmail) {
+			t.Errorf("LookupIssuer(%q) error = %v, want ErrInvalidEmail", email, err)
+		}
+	}
+}
+
+func TestWebFingerClientRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached the loopback server")
+	}))
+	defer server.Close()
+
+	_, err := webFingerClient.Get(server.URL)
+	if !errors.Is(err, ErrNonPublicAddress) {
+		t.Fatalf("error = %v, want ErrNonPublicAddress", err)
+	}
+}
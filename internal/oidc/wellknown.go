@@ -0,0 +1,71 @@
+package oidc
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// wellKnownOpenIDConfiguration is appended to the issuer to get the URL of
+// its OpenID Connect discovery document.
+// https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationRequest
+const wellKnownOpenIDConfiguration = "/.well-known/openid-configuration"
+
+// wellKnownOAuthAuthorizationServer is inserted between the host and the path
+// of the issuer to get the URL of its OAuth 2.0 Authorization Server
+// Metadata.
+// https://datatracker.ietf.org/doc/html/rfc8414#section-3.1
+const wellKnownOAuthAuthorizationServer = "/.well-known/oauth-authorization-server"
+
+// OpenIDConfigurationURL returns the URL of the issuer's OpenID Connect
+// discovery document. A terminating slash is removed from the issuer before
+// the well-known path is appended.
+func OpenIDConfigurationURL(issuer string) string {
+	return strings.TrimSuffix(issuer, "/") + wellKnownOpenIDConfiguration
+}
+
+// AuthorizationServerMetadataURL returns the URL of the issuer's OAuth 2.0
+// Authorization Server Metadata. Unlike OpenID Connect, the well-known path
+// goes before the issuer's path, so https://example.com/tenant1 has its
+// metadata at https://example.com/.well-known/oauth-authorization-server/tenant1.
+// https://datatracker.ietf.org/doc/html/rfc8414#section-3.1
+func AuthorizationServerMetadataURL(issuer string) (string, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return "", fmt.Errorf("invalid issuer: %w", err)
+	}
+
+	// The issuer has no query or fragment.
+	// https://datatracker.ietf.org/doc/html/rfc8414#section-2
+	if u.Scheme == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid issuer: %s", issuer)
+	}
+
+	u.Path = wellKnownOAuthAuthorizationServer + strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// issuerFromDiscoveryURL works out which issuer a discovery document fetched
+// from discoveryURL must have, for both kinds of well-known URL.
+// isOAuthMetadata is true for OAuth 2.0 Authorization Server Metadata URLs.
+// ok is false if discoveryURL is neither.
+func issuerFromDiscoveryURL(discoveryURL string) (issuer string, isOAuthMetadata bool, ok bool) {
+	if prefix, found := strings.CutSuffix(discoveryURL, wellKnownOpenIDConfiguration); found {
+		return prefix, false, true
+	}
+
+	u, err := url.Parse(discoveryURL)
+	if err != nil {
+		return "", false, false
+	}
+
+	path, found := strings.CutPrefix(u.Path, wellKnownOAuthAuthorizationServer)
+	if !found || (path != "" && !strings.HasPrefix(path, "/")) {
+		return "", false, false
+	}
+
+	u.Path = path
+	u.RawPath = ""
+	return u.String(), true, true
+}
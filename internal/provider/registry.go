@@ -0,0 +1,300 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/config"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/oidc"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"golang.org/x/oauth2"
+)
+
+// Provider is an identity provider from the configuration, along with
+// everything needed to run the authorization code flow against it.
+type Provider struct {
+	ID           string
+	Kind         string
+	DisplayName  string
+	DiscoveryURL string
+	ClientID     string
+	ClientSecret oidc.ClientSecretSource
+	RedirectURL  string
+	ResponseMode string
+	Scopes       []string
+	AuthParams   map[string]string
+
+	ClockSkew        time.Duration
+	MaxIssuedAtAge   time.Duration
+	MaxAge           time.Duration
+	AllowedTenantIDs []string
+
+	// OAuth2 is only set for providers of kind config.ProviderKindOAuth2.
+	OAuth2 *config.OAuth2ProviderConfig
+
+	// FrontChannelLogout is nil unless front-channel logout is enabled.
+	FrontChannelLogout *config.FrontChannelLogoutConfig
+
+	discovery *oidc.DiscoveryCache
+}
+
+// ErrNoProviderForEmail is returned when the issuer an email address signs in
+// with isn't one of the configured providers.
+var ErrNoProviderForEmail = errors.New("no configured provider for email")
+
+// Registry holds the configured identity providers.
+type Registry struct {
+	providers []*Provider
+	byID      map[string]*Provider
+	discovery *oidc.DiscoveryCache
+}
+
+// NewRegistry builds a Registry from the providers in cfg.
+func NewRegistry(cfg *config.Config) (*Registry, error) {
+	registry := &Registry{
+		byID: map[string]*Provider{},
+	}
+
+	// Every provider shares one cache, so that providers with the same
+	// discovery URL share a document.
+	discoveryCfg := cfg.DiscoveryConfig
+	discovery := oidc.NewDiscoveryCache(
+		discoveryCfg.DefaultTTL,
+		discoveryCfg.MinTTL,
+		discoveryCfg.MaxTTL,
+		discoveryCfg.RefreshAhead,
+	)
+	discovery.InsecureHosts = discoveryCfg.InsecureHosts
+	registry.discovery = discovery
+
+	for _, providerCfg := range cfg.Providers {
+		clientSecret, err := newClientSecretSource(&providerCfg)
+		if err != nil {
+			return nil, err
+		}
+
+		p := &Provider{
+			ID:           providerCfg.ID,
+			Kind:         providerCfg.Kind,
+			DisplayName:  providerCfg.DisplayName,
+			DiscoveryURL: providerCfg.DiscoveryURL,
+			ClientID:     providerCfg.ClientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  cfg.APIConfig.BaseURL + "/callbacks/" + providerCfg.ID,
+			ResponseMode: providerCfg.ResponseMode,
+			Scopes:       providerCfg.Scopes,
+			AuthParams:   providerCfg.AuthParams,
+
+			ClockSkew:      cfg.IDTokenConfig.ClockSkew,
+			MaxIssuedAtAge: cfg.IDTokenConfig.MaxIssuedAtAge,
+			MaxAge:         time.Duration(providerCfg.MaxAgeSeconds) * time.Second,
+
+			AllowedTenantIDs: providerCfg.AllowedTenantIDs,
+
+			OAuth2:             providerCfg.OAuth2,
+			FrontChannelLogout: providerCfg.FrontChannelLogout,
+
+			discovery: discovery,
+		}
+
+		registry.providers = append(registry.providers, p)
+		registry.byID[p.ID] = p
+	}
+
+	return registry, nil
+}
+
+func newClientSecretSource(providerCfg *config.ProviderConfig) (oidc.ClientSecretSource, error) {
+	jwtCfg := providerCfg.ClientSecretJWT
+	if jwtCfg == nil {
+		return oidc.StaticClientSecret(providerCfg.ClientSecret), nil
+	}
+
+	privateKey, err := oidc.LoadECPrivateKey(jwtCfg.PrivateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %w", providerCfg.ID, err)
+	}
+
+	lifetime := time.Duration(jwtCfg.LifetimeSeconds) * time.Second
+
+	return &oidc.JWTClientSecret{
+		Issuer:        jwtCfg.Issuer,
+		Subject:       providerCfg.ClientID,
+		Audience:      jwtCfg.Audience,
+		KeyID:         jwtCfg.KeyID,
+		PrivateKey:    privateKey,
+		Lifetime:      lifetime,
+		RefreshBefore: lifetime / 10,
+	}, nil
+}
+
+// UsesFormPost reports whether the provider POSTs the authorization
+// response to the callback.
+func (p *Provider) UsesFormPost() bool {
+	return p.ResponseMode == "form_post"
+}
+
+// Get returns the provider with the given ID.
+func (r *Registry) Get(id string) (*Provider, bool) {
+	p, ok := r.byID[id]
+	return p, ok
+}
+
+// All returns every provider, in the order they were configured.
+func (r *Registry) All() []*Provider {
+	return r.providers
+}
+
+// Seed makes sure there is a demo.identity_provider row for every configured
+// provider, since identities reference it.
+func (r *Registry) Seed(ctx context.Context, queries *dal.Queries) error {
+	for _, p := range r.providers {
+		err := queries.UpsertIdentityProvider(ctx, dal.UpsertIdentityProviderParams{
+			ID:          p.ID,
+			DisplayName: p.DisplayName,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to seed identity provider %s: %w", p.ID, err)
+		}
+	}
+	return nil
+}
+
+// Discover fetches the discovery document of every OIDC provider, so that a
+// misconfigured provider or an invalid document is caught at startup rather
+// than during a login.
+func (r *Registry) Discover(ctx context.Context) error {
+	for _, p := range r.providers {
+		if !p.IsOIDC() {
+			continue
+		}
+		discoveryData, err := p.discovery.Get(ctx, p.DiscoveryURL)
+		if err != nil {
+			return fmt.Errorf("provider %s: %w", p.ID, err)
+		}
+
+		// An OAuth 2.0 Authorization Server Metadata URL passes validation
+		// without the fields ID tokens are checked against.
+		if err := discoveryData.ValidateOpenIDConnect(p.DiscoveryURL); err != nil {
+			return fmt.Errorf("provider %s: %w", p.ID, err)
+		}
+	}
+	return nil
+}
+
+// ForEmail finds the provider the user with the given email address signs in
+// with, by asking the email's domain for its issuer through WebFinger. The
+// issuer is only compared against the configured providers. Nothing is
+// fetched from it, since it comes from a domain the user picked.
+func (r *Registry) ForEmail(ctx context.Context, email string) (*Provider, error) {
+	issuer, err := oidc.LookupIssuer(ctx, email, r.discovery.InsecureHosts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range r.providers {
+		if !p.IsOIDC() {
+			continue
+		}
+
+		providerData, err := p.discovery.Get(ctx, p.DiscoveryURL)
+		if err != nil {
+			return nil, fmt.Errorf("provider %s: %w", p.ID, err)
+		}
+		if providerData.MatchesIssuer(issuer) {
+			return p, nil
+		}
+	}
+
+	return nil, ErrNoProviderForEmail
+}
+
+// IsOIDC reports whether the provider speaks OpenID Connect, as opposed to
+// plain OAuth2.
+func (p *Provider) IsOIDC() bool {
+	return p.Kind == config.ProviderKindOIDC
+}
+
+// OAuth2Config builds the oauth2 config for this provider. For OIDC
+// providers the endpoints come from the discovery document.
+func (p *Provider) OAuth2Config(ctx context.Context) (*oauth2.Config, error) {
+	var endpoint oauth2.Endpoint
+	if p.IsOIDC() {
+		discoveryData, err := p.discovery.Get(ctx, p.DiscoveryURL)
+		if err != nil {
+			return nil, err
+		}
+		endpoint = oauth2.Endpoint{
+			AuthURL:  discoveryData.AuthorizationEndpoint,
+			TokenURL: discoveryData.TokenEndpoint,
+		}
+	} else {
+		endpoint = oauth2.Endpoint{
+			AuthURL:  p.OAuth2.AuthorizationURL,
+			TokenURL: p.OAuth2.TokenURL,
+		}
+	}
+
+	clientSecret, err := p.ClientSecret.ClientSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: clientSecret,
+		Endpoint:     endpoint,
+		RedirectURL:  p.RedirectURL,
+		Scopes:       p.Scopes,
+	}, nil
+}
+
+// RevocationEndpoint returns the provider's token revocation endpoint, or an
+// empty string if it doesn't have one.
+func (p *Provider) RevocationEndpoint(ctx context.Context) (string, error) {
+	if !p.IsOIDC() {
+		return p.OAuth2.RevocationURL, nil
+	}
+
+	discoveryData, err := p.discovery.Get(ctx, p.DiscoveryURL)
+	if err != nil {
+		return "", err
+	}
+	return discoveryData.RevocationEndpoint, nil
+}
+
+// EndSessionEndpoint returns the provider's RP-Initiated Logout endpoint, or
+// an empty string if it doesn't have one.
+func (p *Provider) EndSessionEndpoint(ctx context.Context) (string, error) {
+	if !p.IsOIDC() {
+		return "", nil
+	}
+
+	discoveryData, err := p.discovery.Get(ctx, p.DiscoveryURL)
+	if err != nil {
+		return "", err
+	}
+	return discoveryData.EndSessionEndpoint, nil
+}
+
+// OIDCConfig builds the config used to exchange codes and validate ID
+// tokens for this provider.
+func (p *Provider) OIDCConfig(ctx context.Context) (oidc.Config, error) {
+	oauthConfig, err := p.OAuth2Config(ctx)
+	if err != nil {
+		return oidc.Config{}, err
+	}
+
+	return oidc.Config{
+		Config:         oauthConfig,
+		DiscoveryURL:   p.DiscoveryURL,
+		Discovery:      p.discovery,
+		ClockSkew:      p.ClockSkew,
+		MaxIssuedAtAge: p.MaxIssuedAtAge,
+		MaxAge:         p.MaxAge,
+
+		AllowedTenantIDs: p.AllowedTenantIDs,
+	}, nil
+}
@@ -0,0 +1,182 @@
+package providertoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/oidc"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrNoToken is returned when no tokens are stored for the identity.
+	ErrNoToken = errors.New("no token stored for identity")
+
+	// ErrReconsentRequired is returned when the stored tokens can no longer
+	// be used or refreshed. The user has to log in with the provider again.
+	ErrReconsentRequired = errors.New("user has to log in with the provider again")
+)
+
+// refreshTimeout bounds a refresh, including looking up the provider's
+// discovery document. The identity's token row stays locked until the
+// refresh returns, so a provider that never answers mustn't be able to hold
+// the lock, and everyone waiting on it, forever.
+const refreshTimeout = 10 * time.Second
+
+// Service stores the tokens identity providers issue for each identity and
+// hands out access tokens, refreshing them when they are about to expire.
+// Tokens are encrypted before they are written to the database.
+type Service struct {
+	Resolver *deps.Resolver
+}
+
+// Save stores the tokens from a login. If the provider didn't issue a new
+// refresh token, the one already stored is kept.
+func (s *Service) Save(ctx context.Context, identityID pgtype.UUID, token *oauth2.Token) error {
+	return s.save(ctx, s.Resolver.Queries, identityID, token)
+}
+
+// AccessToken returns an access token for the identity that is valid for at
+// least the configured refresh window, refreshing it through the provider's
+// token endpoint if needed. The row is locked while refreshing so that
+// concurrent callers don't race to use a refresh token the provider may only
+// accept once. The refresh itself times out after refreshTimeout.
+func (s *Service) AccessToken(ctx context.Context, identityID pgtype.UUID) (string, error) {
+	tx, err := s.Resolver.DBPool.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	queries := s.Resolver.Queries.WithTx(tx)
+
+	record, err := queries.GetIdentityTokenForUpdate(ctx, identityID)
+	if err == pgx.ErrNoRows {
+		return "", ErrNoToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get token: %v", err)
+	}
+
+	if record.NeedsReconsent {
+		return "", ErrReconsentRequired
+	}
+
+	refreshBefore := s.Resolver.Config.TokenConfig.RefreshBefore
+	if !record.ExpiresAt.Valid || time.Until(record.ExpiresAt.Time) > refreshBefore {
+		accessToken, err := s.decrypt(record.EncryptedAccessToken, identityID)
+		if err != nil {
+			return "", err
+		}
+		return string(accessToken), nil
+	}
+
+	if record.EncryptedRefreshToken == nil {
+		return "", s.requireReconsent(ctx, tx, queries, identityID)
+	}
+
+	refreshToken, err := s.decrypt(record.EncryptedRefreshToken, identityID)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := s.refresh(ctx, record.IdentityProviderID, string(refreshToken))
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+		// The refresh token was revoked or has expired.
+		return "", s.requireReconsent(ctx, tx, queries, identityID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh token: %v", err)
+	}
+
+	// Providers that rotate refresh tokens send a new one with every
+	// refresh, and the old one stops working.
+	if err := s.save(ctx, queries, identityID, token); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", err
+	}
+
+	return token.AccessToken, nil
+}
+
+func (s *Service) refresh(ctx context.Context, providerID, refreshToken string) (*oauth2.Token, error) {
+	idp, ok := s.Resolver.Providers.Get(providerID)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider: %s", providerID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+
+	oauthConfig, err := idp.OAuth2Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	updatedCTX := context.WithValue(ctx, oauth2.HTTPClient, &oidc.HTTPClient)
+
+	token, err := oauthConfig.TokenSource(updatedCTX, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(token.TokenType, "Bearer") {
+		return nil, fmt.Errorf("unexpected token type: %s", token.TokenType)
+	}
+
+	return token, nil
+}
+
+func (s *Service) requireReconsent(ctx context.Context, tx pgx.Tx, queries *dal.Queries, identityID pgtype.UUID) error {
+	if err := queries.MarkIdentityTokenNeedsReconsent(ctx, identityID); err != nil {
+		return fmt.Errorf("failed to flag identity for reconsent: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	return ErrReconsentRequired
+}
+
+func (s *Service) save(ctx context.Context, queries *dal.Queries, identityID pgtype.UUID, token *oauth2.Token) error {
+	keyring := s.Resolver.Keyring
+
+	encryptedAccessToken, err := keyring.Encrypt([]byte(token.AccessToken), identityID.Bytes[:])
+	if err != nil {
+		return fmt.Errorf("failed to encrypt access token: %v", err)
+	}
+
+	var encryptedRefreshToken []byte
+	if token.RefreshToken != "" {
+		encryptedRefreshToken, err = keyring.Encrypt([]byte(token.RefreshToken), identityID.Bytes[:])
+		if err != nil {
+			return fmt.Errorf("failed to encrypt refresh token: %v", err)
+		}
+	}
+
+	return queries.UpsertIdentityToken(ctx, dal.UpsertIdentityTokenParams{
+		IdentityID:            identityID,
+		EncryptedAccessToken:  encryptedAccessToken,
+		EncryptedRefreshToken: encryptedRefreshToken,
+		TokenType:             token.TokenType,
+		ExpiresAt:             pgtype.Timestamptz{Time: token.Expiry, Valid: !token.Expiry.IsZero()},
+	})
+}
+
+func (s *Service) decrypt(ciphertext []byte, identityID pgtype.UUID) ([]byte, error) {
+	plaintext, err := s.Resolver.Keyring.Decrypt(ciphertext, identityID.Bytes[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token: %v", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,205 @@
+package providertoken
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/config"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/encryption"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/provider"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/testdb"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/oauth2"
+)
+
+const testProviderID = "providertoken-test"
+
+// tokenEndpoint answers refresh requests: "valid-refresh" is exchanged for a
+// new access token and a rotated refresh token, anything else is rejected
+// with invalid_grant.
+type tokenEndpoint struct {
+	*httptest.Server
+	hits atomic.Int32
+}
+
+func newTokenEndpoint(t *testing.T) *tokenEndpoint {
+	t.Helper()
+
+	endpoint := &tokenEndpoint{}
+	endpoint.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint.hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.PostFormValue("grant_type") != "refresh_token" || r.PostFormValue("refresh_token") != "valid-refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Write([]byte(`{"access_token":"refreshed-access","token_type":"Bearer","expires_in":3600,"refresh_token":"rotated-refresh"}`))
+	}))
+	t.Cleanup(endpoint.Close)
+
+	return endpoint
+}
+
+func newTestService(t *testing.T, tokenURL string) *Service {
+	t.Helper()
+
+	pool, queries := testdb.Connect(t)
+
+	kek := make([]byte, 32)
+	if _, err := rand.Read(kek); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := encryption.NewKeyring(map[uint32][]byte{1: kek})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		Providers: []config.ProviderConfig{{
+			ID:       testProviderID,
+			Kind:     config.ProviderKindOAuth2,
+			ClientID: "client",
+			OAuth2: &config.OAuth2ProviderConfig{
+				AuthorizationURL: tokenURL,
+				TokenURL:         tokenURL,
+				ProfileURL:       tokenURL,
+			},
+		}},
+		TokenConfig: config.TokenConfig{RefreshBefore: time.Minute},
+	}
+	providers, err := provider.NewRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Service{Resolver: &deps.Resolver{
+		DBPool:    pool,
+		Queries:   queries,
+		Config:    cfg,
+		Providers: providers,
+		Keyring:   keyring,
+	}}
+}
+
+// newIdentityWithToken creates an identity and stores a token for it that
+// expires at expiry.
+func newIdentityWithToken(t *testing.T, s *Service, refreshToken string, expiry time.Time) pgtype.UUID {
+	t.Helper()
+
+	user := testdb.NewUser(t, s.Resolver.Queries)
+	identity := testdb.NewIdentity(t, s.Resolver.Queries, user.ID, testProviderID)
+
+	err := s.Save(context.Background(), identity.ID, &oauth2.Token{
+		AccessToken:  "stored-access",
+		TokenType:    "Bearer",
+		RefreshToken: refreshToken,
+		Expiry:       expiry,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return identity.ID
+}
+
+func TestAccessTokenStillValid(t *testing.T) {
+	endpoint := newTokenEndpoint(t)
+	s := newTestService(t, endpoint.URL)
+	identityID := newIdentityWithToken(t, s, "valid-refresh", time.Now().Add(time.Hour))
+
+	accessToken, err := s.AccessToken(context.Background(), identityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken != "stored-access" {
+		t.Errorf("AccessToken() = %q, want the stored token", accessToken)
+	}
+	if hits := endpoint.hits.Load(); hits != 0 {
+		t.Errorf("token endpoint called %d times, want 0", hits)
+	}
+}
+
+func TestAccessTokenRefreshed(t *testing.T) {
+	endpoint := newTokenEndpoint(t)
+	s := newTestService(t, endpoint.URL)
+	ctx := context.Background()
+
+	// Inside the refresh window, but not expired yet.
+	identityID := newIdentityWithToken(t, s, "valid-refresh", time.Now().Add(30*time.Second))
+
+	accessToken, err := s.AccessToken(ctx, identityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken != "refreshed-access" {
+		t.Errorf("AccessToken() = %q, want the refreshed token", accessToken)
+	}
+
+	record, err := s.Resolver.Queries.GetIdentityTokenForUpdate(ctx, identityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshToken, err := s.decrypt(record.EncryptedRefreshToken, identityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(refreshToken) != "rotated-refresh" {
+		t.Errorf("stored refresh token = %q, want the rotated one", refreshToken)
+	}
+
+	// The refreshed token is good for an hour, so it is served from the
+	// database from now on.
+	accessToken, err = s.AccessToken(ctx, identityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accessToken != "refreshed-access" {
+		t.Errorf("AccessToken() = %q, want the refreshed token", accessToken)
+	}
+	if hits := endpoint.hits.Load(); hits != 1 {
+		t.Errorf("token endpoint called %d times, want 1", hits)
+	}
+}
+
+func TestAccessTokenInvalidGrantRequiresReconsent(t *testing.T) {
+	endpoint := newTokenEndpoint(t)
+	s := newTestService(t, endpoint.URL)
+	ctx := context.Background()
+
+	identityID := newIdentityWithToken(t, s, "revoked-refresh", time.Now().Add(-time.Minute))
+
+	if _, err := s.AccessToken(ctx, identityID); !errors.Is(err, ErrReconsentRequired) {
+		t.Fatalf("AccessToken() error = %v, want ErrReconsentRequired", err)
+	}
+
+	record, err := s.Resolver.Queries.GetIdentityTokenForUpdate(ctx, identityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !record.NeedsReconsent {
+		t.Error("needs_reconsent was not set")
+	}
+	if record.EncryptedRefreshToken != nil {
+		t.Error("the rejected refresh token was kept")
+	}
+
+	// Later calls fail without asking the provider again. The first call may
+	// have taken two requests, since the oauth2 package retries a failed
+	// refresh with the client credentials in the body.
+	hits := endpoint.hits.Load()
+	if _, err := s.AccessToken(ctx, identityID); !errors.Is(err, ErrReconsentRequired) {
+		t.Errorf("second AccessToken() error = %v, want ErrReconsentRequired", err)
+	}
+	if endpoint.hits.Load() != hits {
+		t.Error("token endpoint called again after needs_reconsent was set")
+	}
+}
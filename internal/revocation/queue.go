@@ -0,0 +1,102 @@
+package revocation
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/oidc"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Queue records provider tokens that have to be revoked. Revocation happens
+// later in the background, see Worker, so that a provider outage can't block
+// the request that caused it. Enqueue in the same transaction that deletes
+// the tokens, so that they are either revoked or still stored.
+type Queue struct {
+	Resolver *deps.Resolver
+}
+
+// EnqueueUser queues the tokens of every identity the user has.
+func (q *Queue) EnqueueUser(ctx context.Context, queries *dal.Queries, userID pgtype.UUID) error {
+	records, err := queries.ListUserIdentityTokens(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to list identity tokens: %v", err)
+	}
+
+	for _, record := range records {
+		if err := q.enqueue(ctx, queries, record.IdentityProviderID, record.IdentityID, record.EncryptedAccessToken, record.EncryptedRefreshToken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueIdentity queues the identity's tokens, if it has any.
+func (q *Queue) EnqueueIdentity(ctx context.Context, queries *dal.Queries, identityID pgtype.UUID) error {
+	record, err := queries.GetIdentityTokenForUpdate(ctx, identityID)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get identity token: %v", err)
+	}
+	return q.enqueue(ctx, queries, record.IdentityProviderID, identityID, record.EncryptedAccessToken, record.EncryptedRefreshToken)
+}
+
+// enqueue queues the refresh token if there is one, since revoking it also
+// revokes the access tokens issued with it. Otherwise the access token is
+// queued.
+func (q *Queue) enqueue(
+	ctx context.Context,
+	queries *dal.Queries,
+	providerID string,
+	identityID pgtype.UUID,
+	encryptedAccessToken []byte,
+	encryptedRefreshToken []byte,
+) error {
+	keyring := q.Resolver.Keyring
+
+	encryptedToken, tokenTypeHint := encryptedAccessToken, oidc.TokenTypeHintAccessToken
+	if encryptedRefreshToken != nil {
+		encryptedToken, tokenTypeHint = encryptedRefreshToken, oidc.TokenTypeHintRefreshToken
+	}
+
+	token, err := keyring.Decrypt(encryptedToken, identityID.Bytes[:])
+	if err != nil {
+		return fmt.Errorf("failed to decrypt token: %v", err)
+	}
+
+	// The token is encrypted again, bound to the new row
+	revocationID, err := newUUID()
+	if err != nil {
+		return err
+	}
+
+	encryptedToken, err = keyring.Encrypt(token, revocationID.Bytes[:])
+	if err != nil {
+		return fmt.Errorf("failed to encrypt token: %v", err)
+	}
+
+	return queries.InsertTokenRevocation(ctx, dal.InsertTokenRevocationParams{
+		ID:                 revocationID,
+		IdentityProviderID: providerID,
+		EncryptedToken:     encryptedToken,
+		TokenTypeHint:      tokenTypeHint,
+	})
+}
+
+// newUUID generates a random (version 4) UUID.
+func newUUID() (pgtype.UUID, error) {
+	var id pgtype.UUID
+	if _, err := rand.Read(id.Bytes[:]); err != nil {
+		return pgtype.UUID{}, err
+	}
+	id.Bytes[6] = (id.Bytes[6] & 0x0f) | 0x40
+	id.Bytes[8] = (id.Bytes[8] & 0x3f) | 0x80
+	id.Valid = true
+	return id, nil
+}
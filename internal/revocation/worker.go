@@ -0,0 +1,189 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/encryption"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/oidc"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var errProviderNotConfigured = errors.New("provider is no longer configured")
+
+// maxBackoff caps how long a failed revocation waits before the next attempt.
+const maxBackoff = 6 * time.Hour
+
+// attemptTimeout bounds a single revocation, including looking up the
+// provider's discovery document, so a provider that never answers can't
+// stall the worker.
+const attemptTimeout = 30 * time.Second
+
+// Worker revokes the queued tokens at their providers. Failed attempts are
+// retried with exponential backoff, starting at Interval, until MaxAttempts
+// is reached.
+//
+// A batch is claimed by pushing its next attempt into the future in one short
+// statement, and the providers are called after that, outside any
+// transaction. Several instances can run a Worker at the same time, and if
+// one dies mid-batch, its rows become due again once the claim runs out.
+type Worker struct {
+	Resolver *deps.Resolver
+
+	Interval    time.Duration
+	BatchSize   int32
+	MaxAttempts int32
+}
+
+// Result is what happened to the queued tokens in one run.
+type Result struct {
+	Revoked     int
+	Rescheduled int
+	Dropped     int
+}
+
+// Run processes the queue once immediately and then every Interval, until
+// ctx is done.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		result, err := w.ProcessDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("token revocation failed: %v", err)
+		}
+		if result.Revoked+result.Rescheduled+result.Dropped > 0 {
+			log.Printf(
+				"revoked %d tokens, rescheduled %d and dropped %d",
+				result.Revoked,
+				result.Rescheduled,
+				result.Dropped,
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("token revocation worker stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue attempts every revocation that is due, one batch at a time.
+func (w *Worker) ProcessDue(ctx context.Context) (Result, error) {
+	var result Result
+	queries := w.Resolver.Queries
+
+	// Long enough for every attempt in the batch to time out.
+	claimFor := time.Duration(w.BatchSize) * attemptTimeout
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		records, err := queries.ClaimDueTokenRevocations(ctx, dal.ClaimDueTokenRevocationsParams{
+			ClaimedUntil: pgtype.Timestamptz{Time: time.Now().Add(claimFor), Valid: true},
+			BatchSize:    w.BatchSize,
+		})
+		if err != nil {
+			return result, err
+		}
+
+		for _, record := range records {
+			if err := w.process(ctx, queries, record, &result); err != nil {
+				return result, err
+			}
+		}
+
+		if len(records) < int(w.BatchSize) {
+			return result, nil
+		}
+	}
+}
+
+func (w *Worker) process(ctx context.Context, queries *dal.Queries, record dal.DemoTokenRevocation, result *Result) error {
+	attemptCtx, cancel := context.WithTimeout(ctx, attemptTimeout)
+	err := w.revoke(attemptCtx, record)
+	cancel()
+
+	// Shutting down isn't the provider's fault. The claim runs out and the
+	// row is picked up again.
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err == nil {
+		result.Revoked++
+		return queries.DeleteTokenRevocation(ctx, record.ID)
+	}
+
+	if isPermanent(err) || record.Attempts+1 >= w.MaxAttempts {
+		log.Printf("giving up on revoking token %s at %s: %v", record.ID, record.IdentityProviderID, err)
+		result.Dropped++
+		return queries.DeleteTokenRevocation(ctx, record.ID)
+	}
+
+	result.Rescheduled++
+	return queries.RescheduleTokenRevocation(ctx, dal.RescheduleTokenRevocationParams{
+		ID:            record.ID,
+		NextAttemptAt: pgtype.Timestamptz{Time: time.Now().Add(w.backoff(record.Attempts)), Valid: true},
+		LastError:     pgtype.Text{String: err.Error(), Valid: true},
+	})
+}
+
+func (w *Worker) revoke(ctx context.Context, record dal.DemoTokenRevocation) error {
+	idp, ok := w.Resolver.Providers.Get(record.IdentityProviderID)
+	if !ok {
+		return errProviderNotConfigured
+	}
+
+	revocationEndpoint, err := idp.RevocationEndpoint(ctx)
+	if err != nil {
+		return err
+	}
+	if revocationEndpoint == "" {
+		// Nothing we can do, the provider doesn't support revocation.
+		return nil
+	}
+
+	oauthConfig, err := idp.OAuth2Config(ctx)
+	if err != nil {
+		return err
+	}
+
+	token, err := w.Resolver.Keyring.Decrypt(record.EncryptedToken, record.ID.Bytes[:])
+	if err != nil {
+		return fmt.Errorf("failed to decrypt token: %w", err)
+	}
+
+	return oidc.RevokeToken(ctx, revocationEndpoint, oauthConfig, string(token), record.TokenTypeHint)
+}
+
+// isPermanent reports whether retrying can't help, either because the
+// provider rejected the request itself or because we can't send it.
+func isPermanent(err error) bool {
+	var revocationErr *oidc.RevocationError
+	if errors.As(err, &revocationErr) {
+		return !revocationErr.Temporary()
+	}
+	return errors.Is(err, errProviderNotConfigured) || errors.Is(err, encryption.ErrDecrypt)
+}
+
+func (w *Worker) backoff(attempts int32) time.Duration {
+	backoff := w.Interval
+	for range attempts {
+		backoff *= 2
+		if backoff >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return backoff
+}
@@ -0,0 +1,210 @@
+package revocation
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/config"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/encryption"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/oidc"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/provider"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/testdb"
+	"github.com/jackc/pgx/v5"
+)
+
+func TestIsPermanent(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"invalid token", &oidc.RevocationError{StatusCode: http.StatusBadRequest, ErrorCode: "invalid_token"}, true},
+		{"unauthorized client", &oidc.RevocationError{StatusCode: http.StatusUnauthorized, ErrorCode: "invalid_client"}, true},
+		{"unavailable", &oidc.RevocationError{StatusCode: http.StatusServiceUnavailable}, false},
+		{"server error", &oidc.RevocationError{StatusCode: http.StatusInternalServerError}, false},
+		{"rate limited", &oidc.RevocationError{StatusCode: http.StatusTooManyRequests}, false},
+		{"wrapped rejection", fmt.Errorf("revoking: %w", &oidc.RevocationError{StatusCode: http.StatusBadRequest}), true},
+		{"timeout", context.DeadlineExceeded, false},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, false},
+		{"provider removed", errProviderNotConfigured, true},
+		{"undecryptable token", fmt.Errorf("failed to decrypt token: %w", encryption.ErrDecrypt), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPermanent(tt.err); got != tt.want {
+				t.Errorf("isPermanent(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	w := Worker{Interval: time.Minute}
+
+	tests := []struct {
+		attempts int32
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{5, 32 * time.Minute},
+		{8, 256 * time.Minute},
+		{9, maxBackoff},
+		{1000, maxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := w.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+const testProviderID = "revocation-test"
+
+// TestProcess checks what happens to a queued revocation after one attempt.
+// The provider's answer depends on the token that is revoked.
+func TestProcess(t *testing.T) {
+	pool, queries := testdb.Connect(t)
+	ctx := context.Background()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.PostFormValue("token") {
+		case "revocable":
+			w.WriteHeader(http.StatusOK)
+		case "rejected":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_token"}`))
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	kek := make([]byte, 32)
+	if _, err := rand.Read(kek); err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := encryption.NewKeyring(map[uint32][]byte{1: kek})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{Providers: []config.ProviderConfig{{
+		ID:       testProviderID,
+		Kind:     config.ProviderKindOAuth2,
+		ClientID: "client",
+		OAuth2: &config.OAuth2ProviderConfig{
+			AuthorizationURL: srv.URL,
+			TokenURL:         srv.URL,
+			ProfileURL:       srv.URL,
+			RevocationURL:    srv.URL,
+		},
+	}}}
+	providers, err := provider.NewRegistry(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := queries.UpsertIdentityProvider(ctx, dal.UpsertIdentityProviderParams{ID: testProviderID, DisplayName: testProviderID}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &Worker{
+		Resolver:    &deps.Resolver{DBPool: pool, Queries: queries, Config: cfg, Providers: providers, Keyring: keyring},
+		Interval:    time.Minute,
+		BatchSize:   10,
+		MaxAttempts: 3,
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		attempts    int32
+		want        Result
+		wantDeleted bool
+	}{
+		{"revoked", "revocable", 0, Result{Revoked: 1}, true},
+		{"rejected", "rejected", 0, Result{Dropped: 1}, true},
+		{"unavailable", "unavailable", 0, Result{Rescheduled: 1}, false},
+		{"unavailable after backing off", "unavailable", 1, Result{Rescheduled: 1}, false},
+		{"unavailable on the last attempt", "unavailable", 2, Result{Dropped: 1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := newUUID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			encryptedToken, err := keyring.Encrypt([]byte(tt.token), id.Bytes[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := queries.InsertTokenRevocation(ctx, dal.InsertTokenRevocationParams{
+				ID:                 id,
+				IdentityProviderID: testProviderID,
+				EncryptedToken:     encryptedToken,
+				TokenTypeHint:      oidc.TokenTypeHintRefreshToken,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { queries.DeleteTokenRevocation(ctx, id) })
+
+			record := dal.DemoTokenRevocation{
+				ID:                 id,
+				IdentityProviderID: testProviderID,
+				EncryptedToken:     encryptedToken,
+				TokenTypeHint:      oidc.TokenTypeHintRefreshToken,
+				Attempts:           tt.attempts,
+			}
+			if _, err := pool.Exec(ctx, "update demo.token_revocation set attempts = $2 where id = $1", id, tt.attempts); err != nil {
+				t.Fatal(err)
+			}
+
+			var result Result
+			if err := w.process(ctx, queries, record, &result); err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.want {
+				t.Errorf("result = %+v, want %+v", result, tt.want)
+			}
+
+			var attempts int32
+			var nextAttemptAt time.Time
+			var lastError *string
+			err = pool.QueryRow(ctx, "select attempts, next_attempt_at, last_error from demo.token_revocation where id = $1", id).
+				Scan(&attempts, &nextAttemptAt, &lastError)
+			if tt.wantDeleted {
+				if err != pgx.ErrNoRows {
+					t.Errorf("row still queued, error = %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if attempts != tt.attempts+1 {
+				t.Errorf("attempts = %d, want %d", attempts, tt.attempts+1)
+			}
+			wantNext := time.Now().Add(w.backoff(tt.attempts))
+			if nextAttemptAt.Before(wantNext.Add(-time.Minute)) || nextAttemptAt.After(wantNext.Add(time.Minute)) {
+				t.Errorf("next attempt at %s, want about %s", nextAttemptAt, wantNext)
+			}
+			if lastError == nil || *lastError == "" {
+				t.Error("last_error was not recorded")
+			}
+		})
+	}
+}
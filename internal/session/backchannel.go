@@ -0,0 +1,54 @@
+package session
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/oidc"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrLogoutTokenReplayed is returned when a logout token's jti has been seen
+// before.
+var ErrLogoutTokenReplayed = errors.New("logout token has already been used")
+
+// EndProviderSessions deletes the sessions a validated back-channel logout
+// token refers to and returns how many there were. A token with a sid ends
+// that one session at the provider; a token with only a sub ends every
+// session of that user with the provider.
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#BCActions
+func (s *Service) EndProviderSessions(ctx context.Context, providerID string, token oidc.LogoutToken) (int64, error) {
+	var deleted int64
+
+	err := pgx.BeginFunc(ctx, s.Resolver.DBPool, func(tx pgx.Tx) error {
+		queries := s.Resolver.Queries.WithTx(tx)
+
+		inserted, err := queries.InsertLogoutTokenJTI(ctx, dal.InsertLogoutTokenJTIParams{
+			IdentityProviderID: providerID,
+			Jti:                token.Jti,
+		})
+		if err != nil {
+			return err
+		}
+		if inserted == 0 {
+			return ErrLogoutTokenReplayed
+		}
+
+		if token.Sid != "" {
+			deleted, err = queries.DeleteProviderSessionsBySid(ctx, dal.DeleteProviderSessionsBySidParams{
+				IdentityProviderID: optionalText(providerID),
+				ProviderSid:        optionalText(token.Sid),
+			})
+			return err
+		}
+
+		deleted, err = queries.DeleteProviderSessionsBySubject(ctx, dal.DeleteProviderSessionsBySubjectParams{
+			IdentityProviderID: providerID,
+			ExternalID:         token.Sub,
+		})
+		return err
+	})
+
+	return deleted, err
+}
@@ -0,0 +1,122 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/oidc"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/testdb"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/util"
+)
+
+const backchannelProviderID = "backchannel-test"
+
+func TestEndProviderSessions(t *testing.T) {
+	pool, queries := testdb.Connect(t)
+	ctx := context.Background()
+	s := &Service{Resolver: &deps.Resolver{DBPool: pool, Queries: queries}}
+
+	user := testdb.NewUser(t, queries)
+	identity := testdb.NewIdentity(t, queries, user.ID, backchannelProviderID)
+
+	newSession := func(providerSid string) string {
+		t.Helper()
+		id, err := util.GenerateSecureID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := queries.InsertSession(ctx, dal.InsertSessionParams{
+			ID:                 id,
+			UserID:             user.ID,
+			IdentityID:         identity.ID,
+			IdentityProviderID: optionalText(backchannelProviderID),
+			ProviderSid:        optionalText(providerSid),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	sessionExists := func(id string) bool {
+		t.Helper()
+		var exists bool
+		if err := pool.QueryRow(ctx, "select exists (select 1 from demo.session where id = $1)", id).Scan(&exists); err != nil {
+			t.Fatal(err)
+		}
+		return exists
+	}
+
+	newJTI := func() string {
+		t.Helper()
+		jti, err := util.GenerateSecureID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			pool.Exec(ctx, "delete from demo.logout_token where identity_provider_id = $1 and jti = $2", backchannelProviderID, jti)
+		})
+		return jti
+	}
+
+	t.Run("sid ends that session", func(t *testing.T) {
+		ended := newSession("sid-1")
+		other := newSession("sid-2")
+
+		deleted, err := s.EndProviderSessions(ctx, backchannelProviderID, oidc.LogoutToken{Sid: "sid-1", Sub: identity.ExternalID, Jti: newJTI()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deleted != 1 || sessionExists(ended) || !sessionExists(other) {
+			t.Errorf("deleted %d sessions, want only the one with the sid", deleted)
+		}
+	})
+
+	t.Run("sub ends every session", func(t *testing.T) {
+		first := newSession("sid-3")
+		second := newSession("")
+
+		if _, err := s.EndProviderSessions(ctx, backchannelProviderID, oidc.LogoutToken{Sub: identity.ExternalID, Jti: newJTI()}); err != nil {
+			t.Fatal(err)
+		}
+		if sessionExists(first) || sessionExists(second) {
+			t.Error("sessions of the subject were not deleted")
+		}
+	})
+
+	t.Run("replayed jti", func(t *testing.T) {
+		token := oidc.LogoutToken{Sid: "sid-4", Jti: newJTI()}
+		newSession("sid-4")
+		if _, err := s.EndProviderSessions(ctx, backchannelProviderID, token); err != nil {
+			t.Fatal(err)
+		}
+
+		// The provider's sid is reused by a new login before the token is
+		// replayed.
+		id := newSession("sid-4")
+		deleted, err := s.EndProviderSessions(ctx, backchannelProviderID, token)
+		if !errors.Is(err, ErrLogoutTokenReplayed) {
+			t.Fatalf("error = %v, want ErrLogoutTokenReplayed", err)
+		}
+		if deleted != 0 || !sessionExists(id) {
+			t.Error("a replayed logout token deleted a session")
+		}
+	})
+
+	t.Run("same jti from another provider", func(t *testing.T) {
+		jti := newJTI()
+		if _, err := queries.InsertLogoutTokenJTI(ctx, dal.InsertLogoutTokenJTIParams{IdentityProviderID: backchannelProviderID, Jti: jti}); err != nil {
+			t.Fatal(err)
+		}
+
+		otherIdentity := testdb.NewIdentity(t, queries, user.ID, backchannelProviderID+"-other")
+		t.Cleanup(func() {
+			pool.Exec(ctx, "delete from demo.logout_token where identity_provider_id = $1", backchannelProviderID+"-other")
+		})
+		if _, err := s.EndProviderSessions(ctx, backchannelProviderID+"-other", oidc.LogoutToken{Sub: otherIdentity.ExternalID, Jti: jti}); err != nil {
+			t.Errorf("a jti seen from another provider was rejected: %v", err)
+		}
+	})
+}
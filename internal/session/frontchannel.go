@@ -0,0 +1,54 @@
+package session
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/jackc/pgx/v5"
+)
+
+// EndFrontChannelSessions handles a Front-Channel Logout request from the
+// provider and returns how many sessions were deleted. The sessions created
+// from the provider session sid are deleted. The session of the browser
+// making the request is deleted too if it was logged in with the provider,
+// but only when its cookie is sent along, which browsers often don't do for
+// cross-site iframes. That is why the sid is what really matters.
+// https://openid.net/specs/openid-connect-frontchannel-1_0.html#RPLogout
+func (s *Service) EndFrontChannelSessions(
+	ctx context.Context,
+	w http.ResponseWriter,
+	providerID string,
+	sid string,
+) (int64, error) {
+	var deleted int64
+
+	if sessionID, ok := ctx.Value(sessionContextKey).(string); ok && sessionID != "" {
+		sessionRecord, err := s.Resolver.Queries.GetSession(ctx, sessionID)
+		if err != nil && err != pgx.ErrNoRows {
+			return 0, err
+		}
+
+		loggedInWithProvider := err == nil && sessionRecord.IdentityProviderID.String == providerID
+		if loggedInWithProvider && (sid == "" || sessionRecord.ProviderSid.String == sid) {
+			if err := s.Resolver.Queries.DeleteSession(ctx, sessionID); err != nil {
+				return 0, err
+			}
+			s.DeleteSessionCookie(w)
+			deleted++
+		}
+	}
+
+	if sid != "" {
+		deletedBySid, err := s.Resolver.Queries.DeleteProviderSessionsBySid(ctx, dal.DeleteProviderSessionsBySidParams{
+			IdentityProviderID: optionalText(providerID),
+			ProviderSid:        optionalText(sid),
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += deletedBySid
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,142 @@
+package session
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/oidc"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/util"
+	"github.com/jackc/pgx/v5"
+)
+
+// logoutStateCookieName holds the state sent to the provider's
+// end_session_endpoint, to check when the provider redirects back.
+const logoutStateCookieName = "logout_state"
+
+const logoutStateTTL = 10 * time.Minute
+
+// LogoutCallbackPath is where providers redirect back to after logging the
+// user out. It has to be registered with each provider as a
+// post_logout_redirect_uri.
+const LogoutCallbackPath = "/logout/callback"
+
+// ErrInvalidLogoutState is returned when the state a provider redirected back
+// with doesn't match the one we sent.
+var ErrInvalidLogoutState = errors.New("invalid logout state")
+
+// Logout ends the current session and returns where to send the browser
+// next. If the session was logged in with a provider that supports
+// RP-Initiated Logout, that is the provider's end_session_endpoint, so the
+// user is signed out there too. Otherwise it is the home page.
+// https://openid.net/specs/openid-connect-rpinitiated-1_0.html
+func (s *Service) Logout(ctx context.Context, w http.ResponseWriter) (string, error) {
+	sessionID, ok := ctx.Value(sessionContextKey).(string)
+	if !ok || sessionID == "" {
+		return "/", nil
+	}
+
+	sessionRecord, err := s.Resolver.Queries.GetSession(ctx, sessionID)
+	if err == pgx.ErrNoRows {
+		s.DeleteSessionCookie(w)
+		return "/", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Delete the session from the database
+	if err := s.Resolver.Queries.DeleteSession(ctx, sessionID); err != nil {
+		return "", err
+	}
+
+	s.DeleteSessionCookie(w)
+
+	// The local session is gone either way, so failing to build the logout
+	// URL only means the user stays signed in at the provider.
+	endSessionURL, err := s.endSessionURL(ctx, sessionRecord, w)
+	if err != nil {
+		log.Printf("Failed to build end session URL: %v", err)
+		return "/", nil
+	}
+	if endSessionURL == "" {
+		return "/", nil
+	}
+
+	return endSessionURL, nil
+}
+
+// FinishLogout handles the provider redirecting back after logging the user
+// out.
+func (s *Service) FinishLogout(w http.ResponseWriter, r *http.Request) error {
+	cookie, err := r.Cookie(logoutStateCookieName)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   logoutStateCookieName,
+		Path:   LogoutCallbackPath,
+		MaxAge: -1,
+	})
+
+	state := r.URL.Query().Get("state")
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie.Value)) != 1 {
+		return ErrInvalidLogoutState
+	}
+
+	return nil
+}
+
+func (s *Service) endSessionURL(ctx context.Context, sessionRecord dal.DemoSession, w http.ResponseWriter) (string, error) {
+	if !sessionRecord.IdentityProviderID.Valid {
+		return "", nil
+	}
+
+	idp, ok := s.Resolver.Providers.Get(sessionRecord.IdentityProviderID.String)
+	if !ok {
+		return "", nil
+	}
+
+	endSessionEndpoint, err := idp.EndSessionEndpoint(ctx)
+	if err != nil || endSessionEndpoint == "" {
+		return "", err
+	}
+
+	var idTokenHint string
+	if sessionRecord.EncryptedIDToken != nil {
+		idToken, err := s.Resolver.Keyring.Decrypt(sessionRecord.EncryptedIDToken, []byte(sessionRecord.ID))
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt ID token: %v", err)
+		}
+		idTokenHint = string(idToken)
+	}
+
+	state, err := util.GenerateSecureID()
+	if err != nil {
+		return "", err
+	}
+
+	baseURL := s.Resolver.Config.APIConfig.BaseURL
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     logoutStateCookieName,
+		Value:    state,
+		MaxAge:   int(logoutStateTTL / time.Second),
+		SameSite: http.SameSiteLaxMode,
+		Path:     LogoutCallbackPath,
+		Secure:   strings.HasPrefix(baseURL, "https://"),
+		HttpOnly: true,
+	})
+
+	request := oidc.EndSessionRequest{
+		IDTokenHint:           idTokenHint,
+		ClientID:              idp.ClientID,
+		PostLogoutRedirectURI: baseURL + LogoutCallbackPath,
+		State:                 state,
+	}
+	return request.URL(endSessionEndpoint)
+}
@@ -0,0 +1,117 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ErrSessionNotFound is returned when revoking a session that doesn't exist
+// or doesn't belong to the user.
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionInfo describes one of a user's sessions. The ID is the session's
+// public ID, never the secret that is stored in the cookie.
+type SessionInfo struct {
+	ID         string    `json:"id"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	IPAddress  string    `json:"ipAddress,omitempty"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	Current    bool      `json:"current"`
+}
+
+// ListSessions returns the user's sessions that haven't expired yet, most
+// recently used first.
+func (s *Service) ListSessions(ctx context.Context, userID pgtype.UUID) ([]SessionInfo, error) {
+	sessionCfg := s.Resolver.Config.SessionConfig
+
+	records, err := s.Resolver.Queries.ListActiveUserSessions(ctx, dal.ListActiveUserSessionsParams{
+		UserID:      userID,
+		MaxAge:      pgtype.Interval{Microseconds: sessionCfg.AbsoluteLifetime.Microseconds(), Valid: true},
+		IdleTimeout: pgtype.Interval{Microseconds: sessionCfg.IdleTimeout.Microseconds(), Valid: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	currentPublicID, _ := ctx.Value(sessionPublicIDContextKey).(string)
+
+	sessions := []SessionInfo{}
+	for _, record := range records {
+		publicID := record.PublicID.String()
+		sessions = append(sessions, SessionInfo{
+			ID:         publicID,
+			CreatedAt:  record.CreatedAt.Time,
+			LastSeenAt: record.UpdatedAt.Time,
+			IPAddress:  record.IpAddress.String,
+			UserAgent:  record.UserAgent.String,
+			Current:    publicID == currentPublicID,
+		})
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession deletes one of the user's sessions by its public ID. If it is
+// the session making the request, the session cookie is deleted as well.
+func (s *Service) RevokeSession(
+	ctx context.Context,
+	userID pgtype.UUID,
+	publicID string,
+	w http.ResponseWriter,
+) error {
+	var id pgtype.UUID
+	if err := id.Scan(publicID); err != nil {
+		return ErrSessionNotFound
+	}
+
+	deleted, err := s.Resolver.Queries.DeleteUserSessionByPublicID(ctx, dal.DeleteUserSessionByPublicIDParams{
+		UserID:   userID,
+		PublicID: id,
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrSessionNotFound
+	}
+
+	if currentPublicID, _ := ctx.Value(sessionPublicIDContextKey).(string); currentPublicID == id.String() {
+		s.DeleteSessionCookie(w)
+	}
+
+	return nil
+}
+
+// RevokeOtherSessions deletes all of the user's sessions except the one
+// making the request, and returns how many were deleted.
+func (s *Service) RevokeOtherSessions(ctx context.Context, userID pgtype.UUID) (int64, error) {
+	sessionID, ok := ctx.Value(sessionContextKey).(string)
+	if !ok || sessionID == "" {
+		return 0, fmt.Errorf("no current session")
+	}
+
+	return s.Resolver.Queries.DeleteOtherUserSessions(ctx, dal.DeleteOtherUserSessionsParams{
+		UserID: userID,
+		ID:     sessionID,
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func optionalText(s string) pgtype.Text {
+	return pgtype.Text{String: s, Valid: s != ""}
+}
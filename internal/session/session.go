@@ -2,7 +2,10 @@ package session
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -17,9 +20,10 @@ const sessionCookieName = "session_id"
 
 type contextKey string
 
+// sessionContextKey holds the hashed session ID, i.e. the demo.session.id.
 const sessionContextKey contextKey = "session"
+const sessionPublicIDContextKey contextKey = "session_public_id"
 const userIDContextKey contextKey = "user_id"
-const sessionLifetimeDays = 1
 
 type Service struct {
 	Resolver *deps.Resolver
@@ -36,7 +40,11 @@ func (s *Service) SessionMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		sessionRecord, err := s.Resolver.Queries.GetSession(r.Context(), cookie.Value)
+		// Only the hash of the session ID is stored, so that read access to
+		// the database (or a backup of it) isn't enough to hijack sessions.
+		sessionID := hashSessionID(cookie.Value)
+
+		sessionRecord, err := s.Resolver.Queries.GetSession(r.Context(), sessionID)
 		if err != nil {
 			// If session is invalid, delete the cookie and continue
 			s.DeleteSessionCookie(w)
@@ -44,29 +52,38 @@ func (s *Service) SessionMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Check if session is expired
-		sessionStartedAt := sessionRecord.CreatedAt.Time
-		expiration := sessionStartedAt.Add(sessionLifetimeDays * 24 * time.Hour)
+		sessionCfg := s.Resolver.Config.SessionConfig
+		now := time.Now()
+
+		// Check if session is expired, either because it is too old or
+		// because it hasn't been used in a while.
+		absoluteExpiration := sessionRecord.CreatedAt.Time.Add(sessionCfg.AbsoluteLifetime)
+		idleExpiration := sessionRecord.UpdatedAt.Time.Add(sessionCfg.IdleTimeout)
 
-		if sessionStartedAt.After(expiration) {
+		if now.After(absoluteExpiration) || now.After(idleExpiration) {
 			// If session is expired, delete it and continue without session
-			if err := s.Resolver.Queries.DeleteSession(r.Context(), cookie.Value); err != nil {
+			if err := s.Resolver.Queries.DeleteSession(r.Context(), sessionID); err != nil {
 				http.Error(w, "Failed to delete expired session", http.StatusInternalServerError)
 				return
 			}
-			http.SetCookie(w, &http.Cookie{
-				Name:   sessionCookieName,
-				MaxAge: -1, // Delete the cookie
-			})
+			s.DeleteSessionCookie(w)
 			next.ServeHTTP(w, r)
 			return
 		}
 
+		// Slide the idle timeout forward, but only every so often.
+		if now.Sub(sessionRecord.UpdatedAt.Time) > sessionCfg.TouchInterval {
+			if err := s.Resolver.Queries.TouchSession(r.Context(), sessionID); err != nil {
+				log.Printf("Failed to touch session: %v", err)
+			}
+		}
+
 		// Add session and user IDs to request context
-		ctx1 := context.WithValue(r.Context(), sessionContextKey, cookie.Value)
+		ctx1 := context.WithValue(r.Context(), sessionContextKey, sessionID)
 		ctx2 := context.WithValue(ctx1, userIDContextKey, sessionRecord.UserID.String())
+		ctx3 := context.WithValue(ctx2, sessionPublicIDContextKey, sessionRecord.PublicID.String())
 
-		next.ServeHTTP(w, r.WithContext(ctx2))
+		next.ServeHTTP(w, r.WithContext(ctx3))
 	})
 }
 
@@ -98,16 +115,51 @@ func UserIDFromContext(ctx context.Context) (pgtype.UUID, error) {
 	return uuid, nil
 }
 
-func (s *Service) SaveNewSessionCookie(ctx context.Context, userID pgtype.UUID, w http.ResponseWriter) error {
+// ProviderLogin is what a session was logged in with. It is kept with the
+// session so that logging out can end the user's session at the provider too.
+type ProviderLogin struct {
+	IdentityID pgtype.UUID
+	ProviderID string
+
+	// SID is the provider's session ID, from the sid claim of the ID token.
+	SID string
+
+	// IDToken is the raw ID token, sent as the id_token_hint when logging out.
+	IDToken string
+}
+
+func (s *Service) SaveNewSessionCookie(
+	ctx context.Context,
+	userID pgtype.UUID,
+	login ProviderLogin,
+	w http.ResponseWriter,
+	r *http.Request,
+) error {
 	sessionId, err := util.GenerateSecureID()
 	if err != nil {
 		return err
 	}
+	hashedSessionID := hashSessionID(sessionId)
+
+	var encryptedIDToken []byte
+	if login.IDToken != "" {
+		encryptedIDToken, err = s.Resolver.Keyring.Encrypt([]byte(login.IDToken), []byte(hashedSessionID))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt ID token: %v", err)
+		}
+	}
 
-	// Create a new session in the database
+	// Create a new session in the database, along with enough about the
+	// device for the user to recognize it in their list of sessions.
 	err = s.Resolver.Queries.InsertSession(ctx, dal.InsertSessionParams{
-		ID:     sessionId,
-		UserID: userID,
+		ID:                 hashedSessionID,
+		UserID:             userID,
+		IpAddress:          optionalText(clientIP(r)),
+		UserAgent:          optionalText(r.UserAgent()),
+		IdentityID:         login.IdentityID,
+		IdentityProviderID: optionalText(login.ProviderID),
+		ProviderSid:        optionalText(login.SID),
+		EncryptedIDToken:   encryptedIDToken,
 	})
 	if err != nil {
 		return err
@@ -121,7 +173,7 @@ func (s *Service) SaveNewSessionCookie(ctx context.Context, userID pgtype.UUID,
 	http.SetCookie(w, &http.Cookie{
 		Name:     sessionCookieName,
 		Value:    sessionId,
-		Expires:  time.Now().Add(sessionLifetimeDays * 24 * time.Hour),
+		Expires:  time.Now().Add(s.Resolver.Config.SessionConfig.AbsoluteLifetime),
 		SameSite: http.SameSiteLaxMode,
 		Path:     "/",
 		Secure:   secure,
@@ -131,26 +183,18 @@ func (s *Service) SaveNewSessionCookie(ctx context.Context, userID pgtype.UUID,
 	return nil
 }
 
-func (s *Service) Logout(ctx context.Context, w http.ResponseWriter) error {
-	sessionID, ok := ctx.Value(sessionContextKey).(string)
-	if !ok || sessionID == "" {
-		return nil
-	}
-
-	// Delete the session from the database
-	err := s.Resolver.Queries.DeleteSession(ctx, sessionID)
-	if err != nil {
-		return err
-	}
-
-	s.DeleteSessionCookie(w)
-
-	return nil
-}
-
 func (s *Service) DeleteSessionCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
 		Name:   sessionCookieName,
+		Path:   "/",
 		MaxAge: -1, // Delete the cookie
 	})
 }
+
+// hashSessionID returns the value stored in demo.session.id for the session
+// ID in a cookie. The session ID is 256 random bits, so a plain SHA-256 is
+// enough; there is nothing to brute force.
+func hashSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,5 +0,0 @@
-package dal
-
-const (
-	IdentityProviderIDGoogle = "google"
-)
@@ -9,19 +9,37 @@ import (
 )
 
 type DemoIdentity struct {
-	ID                 pgtype.UUID
-	IdentityProviderID string
-	UserID             pgtype.UUID
-	ExternalID         string
-	MostRecentIDToken  []byte
-	CreatedAt          pgtype.Timestamp
-	UpdatedAt          pgtype.Timestamp
+	ID                         pgtype.UUID
+	IdentityProviderID         string
+	UserID                     pgtype.UUID
+	ExternalID                 string
+	CreatedAt                  pgtype.Timestamp
+	UpdatedAt                  pgtype.Timestamp
+	EncryptedMostRecentIDToken []byte
 }
 
 type DemoIdentityProvider struct {
-	ID        string
-	CreatedAt pgtype.Timestamp
-	UpdatedAt pgtype.Timestamp
+	ID          string
+	CreatedAt   pgtype.Timestamp
+	UpdatedAt   pgtype.Timestamp
+	DisplayName string
+}
+
+type DemoIdentityToken struct {
+	IdentityID            pgtype.UUID
+	EncryptedAccessToken  []byte
+	EncryptedRefreshToken []byte
+	TokenType             string
+	ExpiresAt             pgtype.Timestamptz
+	NeedsReconsent        bool
+	CreatedAt             pgtype.Timestamptz
+	UpdatedAt             pgtype.Timestamptz
+}
+
+type DemoLogoutToken struct {
+	IdentityProviderID string
+	Jti                string
+	CreatedAt          pgtype.Timestamptz
 }
 
 type DemoNonce struct {
@@ -31,16 +49,42 @@ type DemoNonce struct {
 }
 
 type DemoSession struct {
-	ID        string
-	UserID    pgtype.UUID
-	CreatedAt pgtype.Timestamp
-	UpdatedAt pgtype.Timestamp
+	ID                 string
+	UserID             pgtype.UUID
+	CreatedAt          pgtype.Timestamptz
+	UpdatedAt          pgtype.Timestamptz
+	PublicID           pgtype.UUID
+	IpAddress          pgtype.Text
+	UserAgent          pgtype.Text
+	IdentityID         pgtype.UUID
+	IdentityProviderID pgtype.Text
+	ProviderSid        pgtype.Text
+	EncryptedIDToken   []byte
 }
 
 type DemoStateToken struct {
-	Token     string
-	CreatedAt pgtype.Timestamptz
-	UpdatedAt pgtype.Timestamptz
+	Token              string
+	CreatedAt          pgtype.Timestamptz
+	UpdatedAt          pgtype.Timestamptz
+	CodeVerifier       string
+	Nonce              string
+	IdentityProviderID string
+	ReturnTo           string
+	BrowserBinding     string
+	ExpiresAt          pgtype.Timestamptz
+	UserID             pgtype.UUID
+}
+
+type DemoTokenRevocation struct {
+	ID                 pgtype.UUID
+	IdentityProviderID string
+	EncryptedToken     []byte
+	TokenTypeHint      string
+	Attempts           int32
+	NextAttemptAt      pgtype.Timestamptz
+	LastError          pgtype.Text
+	CreatedAt          pgtype.Timestamptz
+	UpdatedAt          pgtype.Timestamptz
 }
 
 type DemoUser struct {
@@ -11,6 +11,249 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+const claimDueTokenRevocations = `-- name: ClaimDueTokenRevocations :many
+update demo.token_revocation
+set next_attempt_at = $1
+where id in (
+    select id
+    from demo.token_revocation
+    where next_attempt_at <= now()
+    order by next_attempt_at
+    limit $2
+    for update skip locked
+)
+returning id, identity_provider_id, encrypted_token, token_type_hint, attempts, next_attempt_at, last_error, created_at, updated_at
+`
+
+type ClaimDueTokenRevocationsParams struct {
+	ClaimedUntil pgtype.Timestamptz
+	BatchSize    int32
+}
+
+func (q *Queries) ClaimDueTokenRevocations(ctx context.Context, arg ClaimDueTokenRevocationsParams) ([]DemoTokenRevocation, error) {
+	rows, err := q.db.Query(ctx, claimDueTokenRevocations, arg.ClaimedUntil, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DemoTokenRevocation
+	for rows.Next() {
+		var i DemoTokenRevocation
+		if err := rows.Scan(
+			&i.ID,
+			&i.IdentityProviderID,
+			&i.EncryptedToken,
+			&i.TokenTypeHint,
+			&i.Attempts,
+			&i.NextAttemptAt,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const consumeStateToken = `-- name: ConsumeStateToken :one
+delete from demo.state_token
+where token = $1
+  and browser_binding = $2
+  and expires_at > now()
+returning token, created_at, updated_at, code_verifier, nonce, identity_provider_id, return_to, browser_binding, expires_at, user_id
+`
+
+type ConsumeStateTokenParams struct {
+	Token          string
+	BrowserBinding string
+}
+
+func (q *Queries) ConsumeStateToken(ctx context.Context, arg ConsumeStateTokenParams) (DemoStateToken, error) {
+	row := q.db.QueryRow(ctx, consumeStateToken, arg.Token, arg.BrowserBinding)
+	var i DemoStateToken
+	err := row.Scan(
+		&i.Token,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.CodeVerifier,
+		&i.Nonce,
+		&i.IdentityProviderID,
+		&i.ReturnTo,
+		&i.BrowserBinding,
+		&i.ExpiresAt,
+		&i.UserID,
+	)
+	return i, err
+}
+
+const countUserIdentities = `-- name: CountUserIdentities :one
+select count(*)
+from demo.identity
+where user_id = $1
+`
+
+func (q *Queries) CountUserIdentities(ctx context.Context, userID pgtype.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countUserIdentities, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const deleteExpiredLogoutTokens = `-- name: DeleteExpiredLogoutTokens :execrows
+delete from demo.logout_token
+where (identity_provider_id, jti) in (
+    select identity_provider_id, jti
+    from demo.logout_token
+    where created_at < now() - $1::interval
+    limit $2
+)
+`
+
+type DeleteExpiredLogoutTokensParams struct {
+	MaxAge    pgtype.Interval
+	BatchSize int32
+}
+
+func (q *Queries) DeleteExpiredLogoutTokens(ctx context.Context, arg DeleteExpiredLogoutTokensParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredLogoutTokens, arg.MaxAge, arg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredNonces = `-- name: DeleteExpiredNonces :execrows
+delete from demo.nonce
+where nonce in (
+    select nonce
+    from demo.nonce
+    where created_at < now() - $1::interval
+    limit $2
+)
+`
+
+type DeleteExpiredNoncesParams struct {
+	MaxAge    pgtype.Interval
+	BatchSize int32
+}
+
+func (q *Queries) DeleteExpiredNonces(ctx context.Context, arg DeleteExpiredNoncesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredNonces, arg.MaxAge, arg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :execrows
+delete from demo.session
+where id in (
+    select id
+    from demo.session
+    where created_at < now() - $1::interval
+       or updated_at < now() - $2::interval
+    limit $3
+)
+`
+
+type DeleteExpiredSessionsParams struct {
+	MaxAge      pgtype.Interval
+	IdleTimeout pgtype.Interval
+	BatchSize   int32
+}
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, arg DeleteExpiredSessionsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredSessions, arg.MaxAge, arg.IdleTimeout, arg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteExpiredStateTokens = `-- name: DeleteExpiredStateTokens :execrows
+delete from demo.state_token
+where token in (
+    select token
+    from demo.state_token
+    where expires_at < now()
+    limit $1
+)
+`
+
+func (q *Queries) DeleteExpiredStateTokens(ctx context.Context, batchSize int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredStateTokens, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteOtherUserSessions = `-- name: DeleteOtherUserSessions :execrows
+delete from demo.session
+where user_id = $1
+  and id <> $2
+`
+
+type DeleteOtherUserSessionsParams struct {
+	UserID pgtype.UUID
+	ID     string
+}
+
+func (q *Queries) DeleteOtherUserSessions(ctx context.Context, arg DeleteOtherUserSessionsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteOtherUserSessions, arg.UserID, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteProviderSessionsBySid = `-- name: DeleteProviderSessionsBySid :execrows
+delete from demo.session
+where identity_provider_id = $1
+  and provider_sid = $2
+`
+
+type DeleteProviderSessionsBySidParams struct {
+	IdentityProviderID pgtype.Text
+	ProviderSid        pgtype.Text
+}
+
+func (q *Queries) DeleteProviderSessionsBySid(ctx context.Context, arg DeleteProviderSessionsBySidParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteProviderSessionsBySid, arg.IdentityProviderID, arg.ProviderSid)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteProviderSessionsBySubject = `-- name: DeleteProviderSessionsBySubject :execrows
+delete from demo.session
+where identity_id in (
+    select id
+    from demo.identity
+    where identity_provider_id = $1
+      and external_id = $2
+)
+`
+
+type DeleteProviderSessionsBySubjectParams struct {
+	IdentityProviderID string
+	ExternalID         string
+}
+
+func (q *Queries) DeleteProviderSessionsBySubject(ctx context.Context, arg DeleteProviderSessionsBySubjectParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteProviderSessionsBySubject, arg.IdentityProviderID, arg.ExternalID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteSession = `-- name: DeleteSession :exec
 delete from demo.session
 where id = $1
@@ -21,13 +264,13 @@ func (q *Queries) DeleteSession(ctx context.Context, id string) error {
 	return err
 }
 
-const deleteStateToken = `-- name: DeleteStateToken :exec
-delete from demo.state_token
-where token = $1
+const deleteTokenRevocation = `-- name: DeleteTokenRevocation :exec
+delete from demo.token_revocation
+where id = $1
 `
 
-func (q *Queries) DeleteStateToken(ctx context.Context, token string) error {
-	_, err := q.db.Exec(ctx, deleteStateToken, token)
+func (q *Queries) DeleteTokenRevocation(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, deleteTokenRevocation, id)
 	return err
 }
 
@@ -40,8 +283,79 @@ func (q *Queries) DeleteUser(ctx context.Context, id pgtype.UUID) error {
 	return err
 }
 
+const deleteUserIdentity = `-- name: DeleteUserIdentity :execrows
+delete from demo.identity
+where id = $1
+  and user_id = $2
+`
+
+type DeleteUserIdentityParams struct {
+	ID     pgtype.UUID
+	UserID pgtype.UUID
+}
+
+func (q *Queries) DeleteUserIdentity(ctx context.Context, arg DeleteUserIdentityParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserIdentity, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteUserSessionByPublicID = `-- name: DeleteUserSessionByPublicID :execrows
+delete from demo.session
+where user_id = $1
+  and public_id = $2
+`
+
+type DeleteUserSessionByPublicIDParams struct {
+	UserID   pgtype.UUID
+	PublicID pgtype.UUID
+}
+
+func (q *Queries) DeleteUserSessionByPublicID(ctx context.Context, arg DeleteUserSessionByPublicIDParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUserSessionByPublicID, arg.UserID, arg.PublicID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getIdentityTokenForUpdate = `-- name: GetIdentityTokenForUpdate :one
+select t.identity_id, t.encrypted_access_token, t.encrypted_refresh_token, t.token_type, t.expires_at, t.needs_reconsent, i.identity_provider_id
+from demo.identity_token t
+join demo.identity i on i.id = t.identity_id
+where t.identity_id = $1
+for update of t
+`
+
+type GetIdentityTokenForUpdateRow struct {
+	IdentityID            pgtype.UUID
+	EncryptedAccessToken  []byte
+	EncryptedRefreshToken []byte
+	TokenType             string
+	ExpiresAt             pgtype.Timestamptz
+	NeedsReconsent        bool
+	IdentityProviderID    string
+}
+
+func (q *Queries) GetIdentityTokenForUpdate(ctx context.Context, identityID pgtype.UUID) (GetIdentityTokenForUpdateRow, error) {
+	row := q.db.QueryRow(ctx, getIdentityTokenForUpdate, identityID)
+	var i GetIdentityTokenForUpdateRow
+	err := row.Scan(
+		&i.IdentityID,
+		&i.EncryptedAccessToken,
+		&i.EncryptedRefreshToken,
+		&i.TokenType,
+		&i.ExpiresAt,
+		&i.NeedsReconsent,
+		&i.IdentityProviderID,
+	)
+	return i, err
+}
+
 const getSession = `-- name: GetSession :one
-select id, user_id, created_at, updated_at
+select id, user_id, created_at, updated_at, public_id, ip_address, user_agent, identity_id, identity_provider_id, provider_sid, encrypted_id_token
 from demo.session
 where id = $1
 `
@@ -54,23 +368,17 @@ func (q *Queries) GetSession(ctx context.Context, id string) (DemoSession, error
 		&i.UserID,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.PublicID,
+		&i.IpAddress,
+		&i.UserAgent,
+		&i.IdentityID,
+		&i.IdentityProviderID,
+		&i.ProviderSid,
+		&i.EncryptedIDToken,
 	)
 	return i, err
 }
 
-const getStateToken = `-- name: GetStateToken :one
-select token, created_at, updated_at
-from demo.state_token
-where token = $1
-`
-
-func (q *Queries) GetStateToken(ctx context.Context, token string) (DemoStateToken, error) {
-	row := q.db.QueryRow(ctx, getStateToken, token)
-	var i DemoStateToken
-	err := row.Scan(&i.Token, &i.CreatedAt, &i.UpdatedAt)
-	return i, err
-}
-
 const getUser = `-- name: GetUser :one
 select id, email, created_at, updated_at
 from demo."user"
@@ -123,18 +431,18 @@ select "user".email as user_email,
         identity.id as identity_id,
         identity.identity_provider_id as identity_provider_id,
         identity.external_id as external_id,
-        identity.most_recent_id_token as most_recent_id_token
+        identity.encrypted_most_recent_id_token as encrypted_most_recent_id_token
 from demo."user"
 left join demo.identity identity on identity.user_id = "user".id
 where "user".id = $1
 `
 
 type GetUserDataRow struct {
-	UserEmail          string
-	IdentityID         pgtype.UUID
-	IdentityProviderID pgtype.Text
-	ExternalID         pgtype.Text
-	MostRecentIDToken  []byte
+	UserEmail                  string
+	IdentityID                 pgtype.UUID
+	IdentityProviderID         pgtype.Text
+	ExternalID                 pgtype.Text
+	EncryptedMostRecentIDToken []byte
 }
 
 func (q *Queries) GetUserData(ctx context.Context, id pgtype.UUID) ([]GetUserDataRow, error) {
@@ -151,7 +459,7 @@ func (q *Queries) GetUserData(ctx context.Context, id pgtype.UUID) ([]GetUserDat
 			&i.IdentityID,
 			&i.IdentityProviderID,
 			&i.ExternalID,
-			&i.MostRecentIDToken,
+			&i.EncryptedMostRecentIDToken,
 		); err != nil {
 			return nil, err
 		}
@@ -163,6 +471,25 @@ func (q *Queries) GetUserData(ctx context.Context, id pgtype.UUID) ([]GetUserDat
 	return items, nil
 }
 
+const insertLogoutTokenJTI = `-- name: InsertLogoutTokenJTI :execrows
+insert into demo.logout_token (identity_provider_id, jti)
+values ($1, $2)
+on conflict do nothing
+`
+
+type InsertLogoutTokenJTIParams struct {
+	IdentityProviderID string
+	Jti                string
+}
+
+func (q *Queries) InsertLogoutTokenJTI(ctx context.Context, arg InsertLogoutTokenJTIParams) (int64, error) {
+	result, err := q.db.Exec(ctx, insertLogoutTokenJTI, arg.IdentityProviderID, arg.Jti)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const insertNonce = `-- name: InsertNonce :exec
 insert into demo.nonce (nonce)
 values ($1)
@@ -174,65 +501,546 @@ func (q *Queries) InsertNonce(ctx context.Context, nonce string) error {
 }
 
 const insertSession = `-- name: InsertSession :exec
-insert into demo.session (id, user_id)
-values ($1, $2)
+insert into demo.session (id, user_id, ip_address, user_agent, identity_id, identity_provider_id, provider_sid, encrypted_id_token)
+values ($1, $2, $3, $4, $5, $6, $7, $8)
 `
 
 type InsertSessionParams struct {
-	ID     string
-	UserID pgtype.UUID
+	ID                 string
+	UserID             pgtype.UUID
+	IpAddress          pgtype.Text
+	UserAgent          pgtype.Text
+	IdentityID         pgtype.UUID
+	IdentityProviderID pgtype.Text
+	ProviderSid        pgtype.Text
+	EncryptedIDToken   []byte
 }
 
 func (q *Queries) InsertSession(ctx context.Context, arg InsertSessionParams) error {
-	_, err := q.db.Exec(ctx, insertSession, arg.ID, arg.UserID)
+	_, err := q.db.Exec(ctx, insertSession,
+		arg.ID,
+		arg.UserID,
+		arg.IpAddress,
+		arg.UserAgent,
+		arg.IdentityID,
+		arg.IdentityProviderID,
+		arg.ProviderSid,
+		arg.EncryptedIDToken,
+	)
 	return err
 }
 
 const insertStateToken = `-- name: InsertStateToken :exec
-insert into demo.state_token (token)
+insert into demo.state_token (token, code_verifier, nonce, identity_provider_id, return_to, browser_binding, expires_at, user_id)
+values ($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+type InsertStateTokenParams struct {
+	Token              string
+	CodeVerifier       string
+	Nonce              string
+	IdentityProviderID string
+	ReturnTo           string
+	BrowserBinding     string
+	ExpiresAt          pgtype.Timestamptz
+	UserID             pgtype.UUID
+}
+
+func (q *Queries) InsertStateToken(ctx context.Context, arg InsertStateTokenParams) error {
+	_, err := q.db.Exec(ctx, insertStateToken,
+		arg.Token,
+		arg.CodeVerifier,
+		arg.Nonce,
+		arg.IdentityProviderID,
+		arg.ReturnTo,
+		arg.BrowserBinding,
+		arg.ExpiresAt,
+		arg.UserID,
+	)
+	return err
+}
+
+const insertTokenRevocation = `-- name: InsertTokenRevocation :exec
+insert into demo.token_revocation (id, identity_provider_id, encrypted_token, token_type_hint)
+values ($1, $2, $3, $4)
+`
+
+type InsertTokenRevocationParams struct {
+	ID                 pgtype.UUID
+	IdentityProviderID string
+	EncryptedToken     []byte
+	TokenTypeHint      string
+}
+
+func (q *Queries) InsertTokenRevocation(ctx context.Context, arg InsertTokenRevocationParams) error {
+	_, err := q.db.Exec(ctx, insertTokenRevocation,
+		arg.ID,
+		arg.IdentityProviderID,
+		arg.EncryptedToken,
+		arg.TokenTypeHint,
+	)
+	return err
+}
+
+const insertUserByEmail = `-- name: InsertUserByEmail :one
+insert into demo."user" (email)
 values ($1)
+on conflict (email) do nothing
+returning id, email, created_at, updated_at
+`
+
+func (q *Queries) InsertUserByEmail(ctx context.Context, email string) (DemoUser, error) {
+	row := q.db.QueryRow(ctx, insertUserByEmail, email)
+	var i DemoUser
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const listActiveUserSessions = `-- name: ListActiveUserSessions :many
+select id, user_id, created_at, updated_at, public_id, ip_address, user_agent, identity_id, identity_provider_id, provider_sid, encrypted_id_token
+from demo.session
+where user_id = $1
+  and created_at > now() - $2::interval
+  and updated_at > now() - $3::interval
+order by updated_at desc
+`
+
+type ListActiveUserSessionsParams struct {
+	UserID      pgtype.UUID
+	MaxAge      pgtype.Interval
+	IdleTimeout pgtype.Interval
+}
+
+func (q *Queries) ListActiveUserSessions(ctx context.Context, arg ListActiveUserSessionsParams) ([]DemoSession, error) {
+	rows, err := q.db.Query(ctx, listActiveUserSessions, arg.UserID, arg.MaxAge, arg.IdleTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DemoSession
+	for rows.Next() {
+		var i DemoSession
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.PublicID,
+			&i.IpAddress,
+			&i.UserAgent,
+			&i.IdentityID,
+			&i.IdentityProviderID,
+			&i.ProviderSid,
+			&i.EncryptedIDToken,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listIdentityClaimsForReencrypt = `-- name: ListIdentityClaimsForReencrypt :many
+select id, encrypted_most_recent_id_token
+from demo.identity
+where encrypted_most_recent_id_token is not null
+  and id > $1
+order by id
+limit $2
+for update
+`
+
+type ListIdentityClaimsForReencryptParams struct {
+	After     pgtype.UUID
+	BatchSize int32
+}
+
+type ListIdentityClaimsForReencryptRow struct {
+	ID                         pgtype.UUID
+	EncryptedMostRecentIDToken []byte
+}
+
+func (q *Queries) ListIdentityClaimsForReencrypt(ctx context.Context, arg ListIdentityClaimsForReencryptParams) ([]ListIdentityClaimsForReencryptRow, error) {
+	rows, err := q.db.Query(ctx, listIdentityClaimsForReencrypt, arg.After, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListIdentityClaimsForReencryptRow
+	for rows.Next() {
+		var i ListIdentityClaimsForReencryptRow
+		if err := rows.Scan(&i.ID, &i.EncryptedMostRecentIDToken); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listIdentityTokensForReencrypt = `-- name: ListIdentityTokensForReencrypt :many
+select identity_id, encrypted_access_token, encrypted_refresh_token
+from demo.identity_token
+where identity_id > $1
+order by identity_id
+limit $2
+for update
+`
+
+type ListIdentityTokensForReencryptParams struct {
+	After     pgtype.UUID
+	BatchSize int32
+}
+
+type ListIdentityTokensForReencryptRow struct {
+	IdentityID            pgtype.UUID
+	EncryptedAccessToken  []byte
+	EncryptedRefreshToken []byte
+}
+
+func (q *Queries) ListIdentityTokensForReencrypt(ctx context.Context, arg ListIdentityTokensForReencryptParams) ([]ListIdentityTokensForReencryptRow, error) {
+	rows, err := q.db.Query(ctx, listIdentityTokensForReencrypt, arg.After, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListIdentityTokensForReencryptRow
+	for rows.Next() {
+		var i ListIdentityTokensForReencryptRow
+		if err := rows.Scan(&i.IdentityID, &i.EncryptedAccessToken, &i.EncryptedRefreshToken); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSessionIDTokensForReencrypt = `-- name: ListSessionIDTokensForReencrypt :many
+select id, encrypted_id_token
+from demo.session
+where id > $1
+  and encrypted_id_token is not null
+order by id
+limit $2
+for update
+`
+
+type ListSessionIDTokensForReencryptParams struct {
+	After     string
+	BatchSize int32
+}
+
+type ListSessionIDTokensForReencryptRow struct {
+	ID               string
+	EncryptedIDToken []byte
+}
+
+func (q *Queries) ListSessionIDTokensForReencrypt(ctx context.Context, arg ListSessionIDTokensForReencryptParams) ([]ListSessionIDTokensForReencryptRow, error) {
+	rows, err := q.db.Query(ctx, listSessionIDTokensForReencrypt, arg.After, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSessionIDTokensForReencryptRow
+	for rows.Next() {
+		var i ListSessionIDTokensForReencryptRow
+		if err := rows.Scan(&i.ID, &i.EncryptedIDToken); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTokenRevocationsForReencrypt = `-- name: ListTokenRevocationsForReencrypt :many
+select id, encrypted_token
+from demo.token_revocation
+where id > $1
+order by id
+limit $2
+for update
 `
 
-func (q *Queries) InsertStateToken(ctx context.Context, token string) error {
-	_, err := q.db.Exec(ctx, insertStateToken, token)
+type ListTokenRevocationsForReencryptParams struct {
+	After     pgtype.UUID
+	BatchSize int32
+}
+
+type ListTokenRevocationsForReencryptRow struct {
+	ID             pgtype.UUID
+	EncryptedToken []byte
+}
+
+func (q *Queries) ListTokenRevocationsForReencrypt(ctx context.Context, arg ListTokenRevocationsForReencryptParams) ([]ListTokenRevocationsForReencryptRow, error) {
+	rows, err := q.db.Query(ctx, listTokenRevocationsForReencrypt, arg.After, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListTokenRevocationsForReencryptRow
+	for rows.Next() {
+		var i ListTokenRevocationsForReencryptRow
+		if err := rows.Scan(&i.ID, &i.EncryptedToken); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserIdentityTokens = `-- name: ListUserIdentityTokens :many
+select t.identity_id, t.encrypted_access_token, t.encrypted_refresh_token, i.identity_provider_id
+from demo.identity_token t
+join demo.identity i on i.id = t.identity_id
+where i.user_id = $1
+`
+
+type ListUserIdentityTokensRow struct {
+	IdentityID            pgtype.UUID
+	EncryptedAccessToken  []byte
+	EncryptedRefreshToken []byte
+	IdentityProviderID    string
+}
+
+func (q *Queries) ListUserIdentityTokens(ctx context.Context, userID pgtype.UUID) ([]ListUserIdentityTokensRow, error) {
+	rows, err := q.db.Query(ctx, listUserIdentityTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUserIdentityTokensRow
+	for rows.Next() {
+		var i ListUserIdentityTokensRow
+		if err := rows.Scan(
+			&i.IdentityID,
+			&i.EncryptedAccessToken,
+			&i.EncryptedRefreshToken,
+			&i.IdentityProviderID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockUser = `-- name: LockUser :exec
+select id
+from demo."user"
+where id = $1
+for update
+`
+
+func (q *Queries) LockUser(ctx context.Context, id pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, lockUser, id)
+	return err
+}
+
+const markIdentityTokenNeedsReconsent = `-- name: MarkIdentityTokenNeedsReconsent :exec
+update demo.identity_token
+set needs_reconsent = true,
+    encrypted_refresh_token = null
+where identity_id = $1
+`
+
+func (q *Queries) MarkIdentityTokenNeedsReconsent(ctx context.Context, identityID pgtype.UUID) error {
+	_, err := q.db.Exec(ctx, markIdentityTokenNeedsReconsent, identityID)
+	return err
+}
+
+const rescheduleTokenRevocation = `-- name: RescheduleTokenRevocation :exec
+update demo.token_revocation
+set attempts = attempts + 1,
+    next_attempt_at = $2,
+    last_error = $3
+where id = $1
+`
+
+type RescheduleTokenRevocationParams struct {
+	ID            pgtype.UUID
+	NextAttemptAt pgtype.Timestamptz
+	LastError     pgtype.Text
+}
+
+func (q *Queries) RescheduleTokenRevocation(ctx context.Context, arg RescheduleTokenRevocationParams) error {
+	_, err := q.db.Exec(ctx, rescheduleTokenRevocation, arg.ID, arg.NextAttemptAt, arg.LastError)
+	return err
+}
+
+const setIdentityClaims = `-- name: SetIdentityClaims :exec
+update demo.identity
+set encrypted_most_recent_id_token = $2
+where id = $1
+`
+
+type SetIdentityClaimsParams struct {
+	ID                         pgtype.UUID
+	EncryptedMostRecentIDToken []byte
+}
+
+func (q *Queries) SetIdentityClaims(ctx context.Context, arg SetIdentityClaimsParams) error {
+	_, err := q.db.Exec(ctx, setIdentityClaims, arg.ID, arg.EncryptedMostRecentIDToken)
+	return err
+}
+
+const touchSession = `-- name: TouchSession :exec
+update demo.session
+set updated_at = now()
+where id = $1
+`
+
+func (q *Queries) TouchSession(ctx context.Context, id string) error {
+	_, err := q.db.Exec(ctx, touchSession, id)
+	return err
+}
+
+const updateIdentityTokenCiphertexts = `-- name: UpdateIdentityTokenCiphertexts :exec
+update demo.identity_token
+set encrypted_access_token = $2,
+    encrypted_refresh_token = $3
+where identity_id = $1
+`
+
+type UpdateIdentityTokenCiphertextsParams struct {
+	IdentityID            pgtype.UUID
+	EncryptedAccessToken  []byte
+	EncryptedRefreshToken []byte
+}
+
+func (q *Queries) UpdateIdentityTokenCiphertexts(ctx context.Context, arg UpdateIdentityTokenCiphertextsParams) error {
+	_, err := q.db.Exec(ctx, updateIdentityTokenCiphertexts, arg.IdentityID, arg.EncryptedAccessToken, arg.EncryptedRefreshToken)
+	return err
+}
+
+const updateSessionIDTokenCiphertext = `-- name: UpdateSessionIDTokenCiphertext :exec
+update demo.session
+set encrypted_id_token = $2
+where id = $1
+`
+
+type UpdateSessionIDTokenCiphertextParams struct {
+	ID               string
+	EncryptedIDToken []byte
+}
+
+func (q *Queries) UpdateSessionIDTokenCiphertext(ctx context.Context, arg UpdateSessionIDTokenCiphertextParams) error {
+	_, err := q.db.Exec(ctx, updateSessionIDTokenCiphertext, arg.ID, arg.EncryptedIDToken)
+	return err
+}
+
+const updateTokenRevocationCiphertext = `-- name: UpdateTokenRevocationCiphertext :exec
+update demo.token_revocation
+set encrypted_token = $2
+where id = $1
+`
+
+type UpdateTokenRevocationCiphertextParams struct {
+	ID             pgtype.UUID
+	EncryptedToken []byte
+}
+
+func (q *Queries) UpdateTokenRevocationCiphertext(ctx context.Context, arg UpdateTokenRevocationCiphertextParams) error {
+	_, err := q.db.Exec(ctx, updateTokenRevocationCiphertext, arg.ID, arg.EncryptedToken)
 	return err
 }
 
 const upsertIdentity = `-- name: UpsertIdentity :one
-insert into demo.identity (user_id, identity_provider_id, external_id, most_recent_id_token)
-values ($1, $2, $3, $4)
+insert into demo.identity (user_id, identity_provider_id, external_id)
+values ($1, $2, $3)
 on conflict (user_id, identity_provider_id, external_id)
-do update set most_recent_id_token = excluded.most_recent_id_token
-returning id, identity_provider_id, user_id, external_id, most_recent_id_token, created_at, updated_at
+do update set updated_at = now()
+returning id, identity_provider_id, user_id, external_id, created_at, updated_at, encrypted_most_recent_id_token
 `
 
 type UpsertIdentityParams struct {
 	UserID             pgtype.UUID
 	IdentityProviderID string
 	ExternalID         string
-	MostRecentIDToken  []byte
 }
 
 func (q *Queries) UpsertIdentity(ctx context.Context, arg UpsertIdentityParams) (DemoIdentity, error) {
-	row := q.db.QueryRow(ctx, upsertIdentity,
-		arg.UserID,
-		arg.IdentityProviderID,
-		arg.ExternalID,
-		arg.MostRecentIDToken,
-	)
+	row := q.db.QueryRow(ctx, upsertIdentity, arg.UserID, arg.IdentityProviderID, arg.ExternalID)
 	var i DemoIdentity
 	err := row.Scan(
 		&i.ID,
 		&i.IdentityProviderID,
 		&i.UserID,
 		&i.ExternalID,
-		&i.MostRecentIDToken,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.EncryptedMostRecentIDToken,
 	)
 	return i, err
 }
 
+const upsertIdentityProvider = `-- name: UpsertIdentityProvider :exec
+insert into demo.identity_provider (id, display_name)
+values ($1, $2)
+on conflict (id) do update set display_name = excluded.display_name
+`
+
+type UpsertIdentityProviderParams struct {
+	ID          string
+	DisplayName string
+}
+
+func (q *Queries) UpsertIdentityProvider(ctx context.Context, arg UpsertIdentityProviderParams) error {
+	_, err := q.db.Exec(ctx, upsertIdentityProvider, arg.ID, arg.DisplayName)
+	return err
+}
+
+const upsertIdentityToken = `-- name: UpsertIdentityToken :exec
+insert into demo.identity_token (identity_id, encrypted_access_token, encrypted_refresh_token, token_type, expires_at)
+values ($1, $2, $3, $4, $5)
+on conflict (identity_id) do update set
+    encrypted_access_token = excluded.encrypted_access_token,
+    encrypted_refresh_token = coalesce(excluded.encrypted_refresh_token, demo.identity_token.encrypted_refresh_token),
+    token_type = excluded.token_type,
+    expires_at = excluded.expires_at,
+    needs_reconsent = false
+`
+
+type UpsertIdentityTokenParams struct {
+	IdentityID            pgtype.UUID
+	EncryptedAccessToken  []byte
+	EncryptedRefreshToken []byte
+	TokenType             string
+	ExpiresAt             pgtype.Timestamptz
+}
+
+func (q *Queries) UpsertIdentityToken(ctx context.Context, arg UpsertIdentityTokenParams) error {
+	_, err := q.db.Exec(ctx, upsertIdentityToken,
+		arg.IdentityID,
+		arg.EncryptedAccessToken,
+		arg.EncryptedRefreshToken,
+		arg.TokenType,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
 const upsertUserByEmail = `-- name: UpsertUserByEmail :one
 insert into demo."user" (email)
 values ($1)
@@ -3,18 +3,16 @@ select *
 from demo."user"
 where id = $1;
 
--- name: GetStateToken :one
-select *
-from demo.state_token
-where token = $1;
-
 -- name: InsertStateToken :exec
-insert into demo.state_token (token)
-values ($1);
+insert into demo.state_token (token, code_verifier, nonce, identity_provider_id, return_to, browser_binding, expires_at, user_id)
+values ($1, $2, $3, $4, $5, $6, $7, $8);
 
--- name: DeleteStateToken :exec
+-- name: ConsumeStateToken :one
 delete from demo.state_token
-where token = $1;
+where token = $1
+  and browser_binding = $2
+  and expires_at > now()
+returning *;
 
 -- name: InsertNonce :exec
 insert into demo.nonce (nonce)
@@ -26,32 +24,71 @@ values ($1)
 on conflict (email) do update set email = excluded.email
 returning *;
 
+-- name: InsertUserByEmail :one
+insert into demo."user" (email)
+values ($1)
+on conflict (email) do nothing
+returning *;
+
+-- name: UpsertIdentityProvider :exec
+insert into demo.identity_provider (id, display_name)
+values ($1, $2)
+on conflict (id) do update set display_name = excluded.display_name;
+
 -- name: UpsertIdentity :one
-insert into demo.identity (user_id, identity_provider_id, external_id, most_recent_id_token)
-values ($1, $2, $3, $4)
+insert into demo.identity (user_id, identity_provider_id, external_id)
+values ($1, $2, $3)
 on conflict (user_id, identity_provider_id, external_id)
-do update set most_recent_id_token = excluded.most_recent_id_token
+do update set updated_at = now()
 returning *;
 
+-- name: SetIdentityClaims :exec
+update demo.identity
+set encrypted_most_recent_id_token = $2
+where id = $1;
+
 -- name: GetSession :one
 select *
 from demo.session
 where id = $1;
 
 -- name: InsertSession :exec
-insert into demo.session (id, user_id)
-values ($1, $2);
+insert into demo.session (id, user_id, ip_address, user_agent, identity_id, identity_provider_id, provider_sid, encrypted_id_token)
+values ($1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ListActiveUserSessions :many
+select *
+from demo.session
+where user_id = sqlc.arg(user_id)
+  and created_at > now() - sqlc.arg(max_age)::interval
+  and updated_at > now() - sqlc.arg(idle_timeout)::interval
+order by updated_at desc;
+
+-- name: DeleteUserSessionByPublicID :execrows
+delete from demo.session
+where user_id = $1
+  and public_id = $2;
+
+-- name: DeleteOtherUserSessions :execrows
+delete from demo.session
+where user_id = $1
+  and id <> $2;
 
 -- name: DeleteSession :exec
 delete from demo.session
 where id = $1;
 
+-- name: TouchSession :exec
+update demo.session
+set updated_at = now()
+where id = $1;
+
 -- name: GetUserData :many
 select "user".email as user_email,
         identity.id as identity_id,
         identity.identity_provider_id as identity_provider_id,
         identity.external_id as external_id,
-        identity.most_recent_id_token as most_recent_id_token
+        identity.encrypted_most_recent_id_token as encrypted_most_recent_id_token
 from demo."user"
 left join demo.identity identity on identity.user_id = "user".id
 where "user".id = $1;
@@ -68,4 +105,182 @@ where exists (
 );
 
 -- name: DeleteUser :exec
-delete from demo."user" where id = $1;
\ No newline at end of file
+delete from demo."user" where id = $1;
+
+-- name: DeleteExpiredStateTokens :execrows
+delete from demo.state_token
+where token in (
+    select token
+    from demo.state_token
+    where expires_at < now()
+    limit sqlc.arg(batch_size)
+);
+
+-- name: DeleteExpiredNonces :execrows
+delete from demo.nonce
+where nonce in (
+    select nonce
+    from demo.nonce
+    where created_at < now() - sqlc.arg(max_age)::interval
+    limit sqlc.arg(batch_size)
+);
+
+-- name: DeleteExpiredSessions :execrows
+delete from demo.session
+where id in (
+    select id
+    from demo.session
+    where created_at < now() - sqlc.arg(max_age)::interval
+       or updated_at < now() - sqlc.arg(idle_timeout)::interval
+    limit sqlc.arg(batch_size)
+);
+-- name: UpsertIdentityToken :exec
+insert into demo.identity_token (identity_id, encrypted_access_token, encrypted_refresh_token, token_type, expires_at)
+values ($1, $2, $3, $4, $5)
+on conflict (identity_id) do update set
+    encrypted_access_token = excluded.encrypted_access_token,
+    encrypted_refresh_token = coalesce(excluded.encrypted_refresh_token, demo.identity_token.encrypted_refresh_token),
+    token_type = excluded.token_type,
+    expires_at = excluded.expires_at,
+    needs_reconsent = false;
+
+-- name: GetIdentityTokenForUpdate :one
+select t.identity_id, t.encrypted_access_token, t.encrypted_refresh_token, t.token_type, t.expires_at, t.needs_reconsent, i.identity_provider_id
+from demo.identity_token t
+join demo.identity i on i.id = t.identity_id
+where t.identity_id = $1
+for update of t;
+
+-- name: MarkIdentityTokenNeedsReconsent :exec
+update demo.identity_token
+set needs_reconsent = true,
+    encrypted_refresh_token = null
+where identity_id = $1;
+
+-- name: ListIdentityTokensForReencrypt :many
+select identity_id, encrypted_access_token, encrypted_refresh_token
+from demo.identity_token
+where identity_id > sqlc.arg(after)
+order by identity_id
+limit sqlc.arg(batch_size)
+for update;
+
+-- name: UpdateIdentityTokenCiphertexts :exec
+update demo.identity_token
+set encrypted_access_token = $2,
+    encrypted_refresh_token = $3
+where identity_id = $1;
+
+-- name: ListIdentityClaimsForReencrypt :many
+select id, encrypted_most_recent_id_token
+from demo.identity
+where encrypted_most_recent_id_token is not null
+  and id > sqlc.arg(after)
+order by id
+limit sqlc.arg(batch_size)
+for update;
+
+-- name: ListUserIdentityTokens :many
+select t.identity_id, t.encrypted_access_token, t.encrypted_refresh_token, i.identity_provider_id
+from demo.identity_token t
+join demo.identity i on i.id = t.identity_id
+where i.user_id = $1;
+
+-- name: CountUserIdentities :one
+select count(*)
+from demo.identity
+where user_id = $1;
+
+-- name: DeleteUserIdentity :execrows
+delete from demo.identity
+where id = $1
+  and user_id = $2;
+
+-- name: InsertTokenRevocation :exec
+insert into demo.token_revocation (id, identity_provider_id, encrypted_token, token_type_hint)
+values ($1, $2, $3, $4);
+
+-- name: ClaimDueTokenRevocations :many
+update demo.token_revocation
+set next_attempt_at = sqlc.arg(claimed_until)
+where id in (
+    select id
+    from demo.token_revocation
+    where next_attempt_at <= now()
+    order by next_attempt_at
+    limit sqlc.arg(batch_size)
+    for update skip locked
+)
+returning *;
+
+-- name: DeleteTokenRevocation :exec
+delete from demo.token_revocation
+where id = $1;
+
+-- name: RescheduleTokenRevocation :exec
+update demo.token_revocation
+set attempts = attempts + 1,
+    next_attempt_at = $2,
+    last_error = $3
+where id = $1;
+
+-- name: ListTokenRevocationsForReencrypt :many
+select id, encrypted_token
+from demo.token_revocation
+where id > sqlc.arg(after)
+order by id
+limit sqlc.arg(batch_size)
+for update;
+
+-- name: UpdateTokenRevocationCiphertext :exec
+update demo.token_revocation
+set encrypted_token = $2
+where id = $1;
+
+-- name: ListSessionIDTokensForReencrypt :many
+select id, encrypted_id_token
+from demo.session
+where id > sqlc.arg(after)
+  and encrypted_id_token is not null
+order by id
+limit sqlc.arg(batch_size)
+for update;
+
+-- name: UpdateSessionIDTokenCiphertext :exec
+update demo.session
+set encrypted_id_token = $2
+where id = $1;
+
+-- name: LockUser :exec
+select id
+from demo."user"
+where id = $1
+for update;
+
+-- name: InsertLogoutTokenJTI :execrows
+insert into demo.logout_token (identity_provider_id, jti)
+values ($1, $2)
+on conflict do nothing;
+
+-- name: DeleteProviderSessionsBySid :execrows
+delete from demo.session
+where identity_provider_id = $1
+  and provider_sid = $2;
+
+-- name: DeleteProviderSessionsBySubject :execrows
+delete from demo.session
+where identity_id in (
+    select id
+    from demo.identity
+    where identity_provider_id = $1
+      and external_id = $2
+);
+
+-- name: DeleteExpiredLogoutTokens :execrows
+delete from demo.logout_token
+where (identity_provider_id, jti) in (
+    select identity_provider_id, jti
+    from demo.logout_token
+    where created_at < now() - sqlc.arg(max_age)::interval
+    limit sqlc.arg(batch_size)
+);
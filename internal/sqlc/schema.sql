@@ -18,7 +18,8 @@ CREATE TABLE demo."user" (
 CREATE TABLE demo.identity_provider (
     id text NOT NULL,
     created_at timestamp without time zone DEFAULT now(),
-    updated_at timestamp without time zone DEFAULT now()
+    updated_at timestamp without time zone DEFAULT now(),
+    display_name text DEFAULT ''::text NOT NULL
 );
 
 CREATE TABLE demo.identity (
@@ -26,15 +27,22 @@ CREATE TABLE demo.identity (
     identity_provider_id text NOT NULL,
     user_id uuid NOT NULL,
     external_id text NOT NULL,
-    most_recent_id_token jsonb,
     created_at timestamp without time zone DEFAULT now(),
-    updated_at timestamp without time zone DEFAULT now()
+    updated_at timestamp without time zone DEFAULT now(),
+    encrypted_most_recent_id_token bytea
 );
 
 CREATE TABLE demo.state_token (
     token text NOT NULL,
     created_at timestamp with time zone DEFAULT now(),
-    updated_at timestamp with time zone DEFAULT now()
+    updated_at timestamp with time zone DEFAULT now(),
+    code_verifier text NOT NULL,
+    nonce text NOT NULL,
+    identity_provider_id text NOT NULL,
+    return_to text DEFAULT '/'::text NOT NULL,
+    browser_binding text NOT NULL,
+    expires_at timestamp with time zone NOT NULL,
+    user_id uuid
 );
 
 CREATE TABLE demo.nonce (
@@ -46,6 +54,42 @@ CREATE TABLE demo.nonce (
 CREATE TABLE demo.session (
     id text NOT NULL,
     user_id uuid NOT NULL,
-    created_at timestamp without time zone DEFAULT now(),
-    updated_at timestamp without time zone DEFAULT now()
-);
\ No newline at end of file
+    created_at timestamp with time zone DEFAULT now(),
+    updated_at timestamp with time zone DEFAULT now(),
+    public_id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    ip_address text,
+    user_agent text,
+    identity_id uuid,
+    identity_provider_id text,
+    provider_sid text,
+    encrypted_id_token bytea
+);
+
+CREATE TABLE demo.identity_token (
+    identity_id uuid NOT NULL,
+    encrypted_access_token bytea NOT NULL,
+    encrypted_refresh_token bytea,
+    token_type text NOT NULL,
+    expires_at timestamp with time zone,
+    needs_reconsent boolean DEFAULT false NOT NULL,
+    created_at timestamp with time zone DEFAULT now(),
+    updated_at timestamp with time zone DEFAULT now()
+);
+
+CREATE TABLE demo.token_revocation (
+    id uuid NOT NULL,
+    identity_provider_id text NOT NULL,
+    encrypted_token bytea NOT NULL,
+    token_type_hint text NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    next_attempt_at timestamp with time zone DEFAULT now() NOT NULL,
+    last_error text,
+    created_at timestamp with time zone DEFAULT now(),
+    updated_at timestamp with time zone DEFAULT now()
+);
+
+CREATE TABLE demo.logout_token (
+    identity_provider_id text NOT NULL,
+    jti text NOT NULL,
+    created_at timestamp with time zone DEFAULT now()
+);
@@ -3,9 +3,13 @@ package user
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/Nick-Anderssohn/oidc-demo/internal/deps"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/revocation"
+	"github.com/Nick-Anderssohn/oidc-demo/internal/sqlc/dal"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -22,6 +26,16 @@ type Identity struct {
 	MostRecentIDToken  json.RawMessage `json:"mostRecentIdToken"`
 }
 
+var (
+	// ErrIdentityNotFound is returned when unlinking an identity that doesn't
+	// exist or doesn't belong to the user.
+	ErrIdentityNotFound = errors.New("identity not found")
+
+	// ErrLastIdentity is returned when unlinking the only identity the user
+	// can log in with.
+	ErrLastIdentity = errors.New("cannot unlink the last identity")
+)
+
 type Service struct {
 	Resolver *deps.Resolver
 }
@@ -37,11 +51,19 @@ func (s *Service) GetUserData(ctx context.Context, userID pgtype.UUID) (*UserDat
 
 	identities := []*Identity{}
 	for _, userData := range userDataSlice {
+		var mostRecentIDToken []byte
+		if userData.EncryptedMostRecentIDToken != nil {
+			mostRecentIDToken, err = s.Resolver.Keyring.Decrypt(userData.EncryptedMostRecentIDToken, userData.IdentityID.Bytes[:])
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt ID token payload: %v", err)
+			}
+		}
+
 		identities = append(identities, &Identity{
 			ID:                 userData.IdentityID.String(),
 			IdentityProviderID: userData.IdentityProviderID.String,
 			ExternalID:         userData.ExternalID.String,
-			MostRecentIDToken:  userData.MostRecentIDToken,
+			MostRecentIDToken:  mostRecentIDToken,
 		})
 	}
 
@@ -51,3 +73,63 @@ func (s *Service) GetUserData(ctx context.Context, userID pgtype.UUID) (*UserDat
 		Identities: identities,
 	}, nil
 }
+
+// DeleteUser deletes the user along with their identities, and queues the
+// tokens the providers issued for them to be revoked.
+func (s *Service) DeleteUser(ctx context.Context, userID pgtype.UUID) error {
+	return pgx.BeginFunc(ctx, s.Resolver.DBPool, func(tx pgx.Tx) error {
+		queries := s.Resolver.Queries.WithTx(tx)
+
+		revocationQueue := revocation.Queue{Resolver: s.Resolver}
+		if err := revocationQueue.EnqueueUser(ctx, queries, userID); err != nil {
+			return err
+		}
+
+		return queries.DeleteUser(ctx, userID)
+	})
+}
+
+// UnlinkIdentity removes one of the user's identities and queues its tokens
+// to be revoked. The user has to keep at least one identity to log in with.
+func (s *Service) UnlinkIdentity(ctx context.Context, userID pgtype.UUID, identityID string) error {
+	var id pgtype.UUID
+	if err := id.Scan(identityID); err != nil {
+		return ErrIdentityNotFound
+	}
+
+	return pgx.BeginFunc(ctx, s.Resolver.DBPool, func(tx pgx.Tx) error {
+		queries := s.Resolver.Queries.WithTx(tx)
+
+		// Serializes concurrent unlinks, so they can't remove every identity
+		// between them.
+		if err := queries.LockUser(ctx, userID); err != nil {
+			return err
+		}
+
+		count, err := queries.CountUserIdentities(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if count <= 1 {
+			return ErrLastIdentity
+		}
+
+		revocationQueue := revocation.Queue{Resolver: s.Resolver}
+		if err := revocationQueue.EnqueueIdentity(ctx, queries, id); err != nil {
+			return err
+		}
+
+		deleted, err := queries.DeleteUserIdentity(ctx, dal.DeleteUserIdentityParams{
+			ID:     id,
+			UserID: userID,
+		})
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			// Rolls back the revocation, the identity belongs to someone else.
+			return ErrIdentityNotFound
+		}
+		return nil
+	})
+}